@@ -0,0 +1,249 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+const (
+	// AnnotationBaseName is the OCI annotation for the reference of an image's base image
+	AnnotationBaseName = "org.opencontainers.image.base.name"
+	// AnnotationBaseDigest is the OCI annotation for the digest of an image's base image
+	AnnotationBaseDigest = "org.opencontainers.image.base.digest"
+)
+
+// ImageAgePolicy controls how images that are too old are treated
+type ImageAgePolicy string
+
+const (
+	ImageAgeFail ImageAgePolicy = "fail" // Images that are too old fail the check (the default)
+	ImageAgeWarn ImageAgePolicy = "warn" // Images that are too old are only logged
+)
+
+// ParseImageAgePolicy parses the name of an image age policy
+func ParseImageAgePolicy(str string) (ImageAgePolicy, error) {
+	switch p := ImageAgePolicy(str); p {
+	case ImageAgeFail, ImageAgeWarn:
+		return p, nil
+	case "":
+		return ImageAgeFail, nil
+	default:
+		return "", fmt.Errorf("unknown image age policy %q, should be fail or warn", str)
+	}
+}
+
+// ImageAge describes when an image, and its base image, were created
+type ImageAge struct {
+	Created     *time.Time // When the image was created, nil if unknown
+	BaseCreated *time.Time // When the base image was created, nil if unknown
+	Base        string     // The base image, from the image's annotations, if present
+}
+
+// ImageAgeError is returned by AgeChecker if an image, or its base image, is too old
+type ImageAgeError struct {
+	Created time.Time
+	Image   string
+	Base    string // Set if the base image is too old
+	MaxAge  time.Duration
+}
+
+func (iae *ImageAgeError) Error() string {
+	img := "image " + iae.Image
+	if iae.Base != "" {
+		img = fmt.Sprintf("base image %s of %s", iae.Base, iae.Image)
+	}
+	return fmt.Sprintf("%s was created at %s, more than %s ago", img, iae.Created.Format(time.RFC3339), iae.MaxAge)
+}
+
+// AgeChecker checks that images, and the base images they were built from, are not
+// older than a maximum age. Images are dated by the created time in their config,
+// or their created annotation. Base images are found from the OCI base image
+// annotations, on the image's manifest or index, or the labels in its config.
+type AgeChecker struct {
+	Logger
+	IgnoreImages *regexp.Regexp
+	now          func() time.Time // for testing
+	Options      []remote.Option
+	MaxAge       time.Duration // Maximum age of images, not checked if 0
+	MaxBaseAge   time.Duration // Maximum age of base images, not checked if 0
+	Concurrency  int           // Maximum number of images checked at once by CheckImages, unlimited if 0
+}
+
+// ImageAgeCheck is the outcome of checking one of the images passed to CheckImages
+type ImageAgeCheck struct {
+	Err error     // Why the check failed, an *ImageAgeError if the image, or its base, is too old
+	Age *ImageAge // The ages found, if they could be read
+}
+
+func (ac *AgeChecker) options(ctx context.Context) []remote.Option {
+	return append([]remote.Option{remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain)}, ac.Options...)
+}
+
+// imageDetails returns the created time of the image, (nil if it has none), and
+// its annotations and labels
+func (ac *AgeChecker) imageDetails(ctx context.Context, ref name.Reference) (*time.Time, map[string]string, error) {
+	desc, err := remote.Get(ref, ac.options(ctx)...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read %s, %w", ref, err)
+	}
+
+	annos := map[string]string{}
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read index %s, %w", ref, err)
+		}
+		im, err := idx.IndexManifest()
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read index %s, %w", ref, err)
+		}
+		for k, v := range im.Annotations {
+			annos[k] = v
+		}
+	}
+
+	// for indexes, this selects the image for the default platform
+	img, err := desc.Image()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read image %s, %w", ref, err)
+	}
+	mf, err := img.Manifest()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read manifest of %s, %w", ref, err)
+	}
+	for k, v := range mf.Annotations {
+		annos[k] = v
+	}
+	cf, err := img.ConfigFile()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read config of %s, %w", ref, err)
+	}
+	// annotations take precedence over labels
+	for k, v := range cf.Config.Labels {
+		if _, ok := annos[k]; !ok {
+			annos[k] = v
+		}
+	}
+
+	// reproducible builds often set the created time to the epoch, which tells
+	// us nothing about the image's age
+	var created *time.Time
+	if t := cf.Created.Time; t.Unix() > 0 {
+		created = &t
+	} else if t, err := time.Parse(time.RFC3339, annos[AnnotationCreated]); err == nil && t.Unix() > 0 {
+		created = &t
+	}
+
+	return created, annos, nil
+}
+
+// baseReference returns a reference to the base image described by the annotations,
+// by digest if it is known
+func baseReference(annos map[string]string) (name.Reference, error) {
+	str := annos[AnnotationBaseName]
+	if str == "" {
+		return nil, nil
+	}
+	ref, err := ParseReference(str)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse base image %q, %w", str, err)
+	}
+	if dig := annos[AnnotationBaseDigest]; dig != "" {
+		return ref.Context().Digest(dig), nil
+	}
+	return ref, nil
+}
+
+// Check reads the ages of the image, and its base image, and checks them against
+// the maximum ages. Images with no created time are not checked.
+func (ac *AgeChecker) Check(ctx context.Context, dig name.Digest) (*ImageAge, error) {
+	log := ac.Logger
+	if log == nil {
+		log = DefaultLogger
+	}
+
+	now := time.Now()
+	if ac.now != nil {
+		now = ac.now()
+	}
+
+	res := ImageAge{}
+	if ac.IgnoreImages != nil && ac.IgnoreImages.MatchString(dig.String()) {
+		return &res, nil
+	}
+
+	created, annos, err := ac.imageDetails(ctx, dig)
+	if err != nil {
+		return nil, err
+	}
+	res.Created = created
+	if created == nil {
+		log.Debug("image has no created time, age not checked", "img", dig.String())
+	}
+
+	base, err := baseReference(annos)
+	if err != nil {
+		return nil, err
+	}
+	if base != nil {
+		res.Base = base.String()
+		if ac.MaxBaseAge != 0 {
+			res.BaseCreated, _, err = ac.imageDetails(ctx, base)
+			if err != nil {
+				return &res, fmt.Errorf("could not check base image of %s, %w", dig, err)
+			}
+			if res.BaseCreated == nil {
+				log.Debug("base image has no created time, age not checked", "img", dig.String(), "base", res.Base)
+			}
+		}
+	}
+
+	if ac.MaxAge != 0 && created != nil && now.Sub(*created) > ac.MaxAge {
+		return &res, &ImageAgeError{Image: dig.String(), Created: *created, MaxAge: ac.MaxAge}
+	}
+	if ac.MaxBaseAge != 0 && res.BaseCreated != nil && now.Sub(*res.BaseCreated) > ac.MaxBaseAge {
+		return &res, &ImageAgeError{Image: dig.String(), Base: res.Base, Created: *res.BaseCreated, MaxAge: ac.MaxBaseAge}
+	}
+
+	return &res, nil
+}
+
+// CheckImages checks the ages of all the images, running at most Concurrency checks
+// at once. The outcome of each check is returned, keyed as in imgs, along with the
+// errors of any checks that failed.
+func (ac *AgeChecker) CheckImages(ctx context.Context, imgs map[string]QualifiedImage) (map[string]ImageAgeCheck, error) {
+	srcs := make([]string, 0, len(imgs))
+	for src := range imgs {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+
+	checks := make([]ImageAgeCheck, len(srcs))
+	forEach(len(srcs), ac.Concurrency, func(i int) {
+		dig, err := imgs[srcs[i]].DigestReference()
+		if err != nil {
+			checks[i].Err = err
+			return
+		}
+		checks[i].Age, checks[i].Err = ac.Check(ctx, dig)
+	})
+
+	res := make(map[string]ImageAgeCheck, len(srcs))
+	errs := make([]error, len(srcs))
+	for i, src := range srcs {
+		res[src] = checks[i]
+		errs[i] = checks[i].Err
+	}
+
+	return res, joinErrors(errs)
+}
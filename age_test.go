@@ -0,0 +1,106 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestAgeChecker(t *testing.T) {
+	host := newTestRegistry(t)
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// push pushes an image created at the given time, with the given annotations,
+	// and returns its digest
+	push := func(tag string, created time.Time, annos map[string]string) name.Digest {
+		t.Helper()
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err = mutate.CreatedAt(img, v1.Time{Time: created})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if annos != nil {
+			img, _ = mutate.Annotations(img, annos).(v1.Image)
+		}
+		return pushImage(t, fmt.Sprintf("%s/%s", host, tag), img)
+	}
+
+	oldBase := push("library/alpine:3.12", now.Add(-3*365*24*time.Hour), nil)
+	newBase := push("library/alpine:3.20", now.Add(-30*24*time.Hour), nil)
+
+	var tests = []struct {
+		dig     name.Digest
+		base    string // the base image that is too old, if any
+		tooOld  bool
+		created bool
+	}{
+		{dig: push("app/fresh:1", now.Add(-24*time.Hour), nil), created: true},
+		{dig: push("app/old:1", now.Add(-400*24*time.Hour), nil), created: true, tooOld: true},
+		// reproducible builds have no meaningful created time
+		{dig: push("app/reproducible:1", time.Unix(0, 0), nil)},
+		{
+			dig: push("app/oldbase:1", now.Add(-24*time.Hour), map[string]string{
+				AnnotationBaseName:   oldBase.Context().Tag("3.12").String(),
+				AnnotationBaseDigest: oldBase.DigestStr(),
+			}),
+			created: true,
+			tooOld:  true,
+			base:    oldBase.String(),
+		},
+		{
+			dig: push("app/newbase:1", now.Add(-24*time.Hour), map[string]string{
+				AnnotationBaseName:   newBase.Context().Tag("3.20").String(),
+				AnnotationBaseDigest: newBase.DigestStr(),
+			}),
+			created: true,
+		},
+		// without a digest, the base image's tag is used
+		{
+			dig: push("app/oldbasetag:1", now.Add(-24*time.Hour), map[string]string{
+				AnnotationBaseName: oldBase.Context().Tag("3.12").String(),
+			}),
+			created: true,
+			tooOld:  true,
+			base:    oldBase.Context().Tag("3.12").String(),
+		},
+	}
+
+	ac := &AgeChecker{
+		MaxAge:     365 * 24 * time.Hour,
+		MaxBaseAge: 2 * 365 * 24 * time.Hour,
+		now:        func() time.Time { return now },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dig.RepositoryStr(), func(t *testing.T) {
+			age, err := ac.Check(context.Background(), tt.dig)
+			iae := &ImageAgeError{}
+			if tt.tooOld != errors.As(err, &iae) {
+				t.Fatalf("expected too old to be %v, got %v", tt.tooOld, err)
+			}
+			if !tt.tooOld && err != nil {
+				t.Fatalf("unexpected error, %v", err)
+			}
+			if tt.tooOld && iae.Base != tt.base {
+				t.Fatalf("incorrect base image, got %q, exp %q", iae.Base, tt.base)
+			}
+			if tt.created != (age.Created != nil) {
+				t.Fatalf("incorrect created time, got %v", age.Created)
+			}
+		})
+	}
+}
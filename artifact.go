@@ -0,0 +1,321 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	// MappingsArtifactType is the artifactType of stored mappings
+	MappingsArtifactType types.MediaType = "application/vnd.cerbos.reimage.mappings.v1"
+	// MappingsMediaType is the media type of the blob holding the mappings document
+	MappingsMediaType types.MediaType = "application/vnd.cerbos.reimage.mappings.v1+json"
+
+	// AnnotationCreated records when the mappings were created
+	AnnotationCreated = "org.opencontainers.image.created"
+	// AnnotationReimageVersion records the version of reimage that wrote the mappings
+	AnnotationReimageVersion = "dev.cerbos.reimage.version"
+	// AnnotationSource records the input the mappings were generated from
+	AnnotationSource = "dev.cerbos.reimage.source"
+	// AnnotationMappingsSources records any static mappings the mappings were built from
+	AnnotationMappingsSources = "dev.cerbos.reimage.mappings-sources"
+	// AnnotationSignature holds the base64 encoded signature of the mappings blob
+	AnnotationSignature = "dev.cerbos.reimage.signature"
+	// AnnotationSignatureKey holds the ID of the key that signed the mappings blob
+	AnnotationSignatureKey = "dev.cerbos.reimage.signature-key"
+
+	// OCIEmptyMediaType is the media type of the empty config of OCI artifacts
+	OCIEmptyMediaType types.MediaType = "application/vnd.oci.empty.v1+json"
+
+	mappingsFileName = "reimage-mapping.json"
+)
+
+// ociEmptyConfig is the content of the empty config of OCI artifacts
+var ociEmptyConfig = []byte("{}")
+
+// ErrMappingsNotSigned is returned when verifying a mappings artifact that has
+// no signature
+var ErrMappingsNotSigned = errors.New("mappings artifact is not signed")
+
+// NewMappingsArtifact creates an OCI artifact holding the mappings document as
+// a single, untarred, JSON blob. The document metadata is recorded in the
+// manifest annotations.
+func NewMappingsArtifact(doc *MappingsDocument) (v1.Image, error) {
+	bs, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal mappings, %w", err)
+	}
+
+	return newMappingsArtifact(bs, doc.Metadata, nil)
+}
+
+// NewSignedMappingsArtifact creates a mappings artifact, as NewMappingsArtifact,
+// and signs the mappings blob with keys. The signature is stored in the manifest
+// annotations.
+func NewSignedMappingsArtifact(ctx context.Context, doc *MappingsDocument, keys Keyer) (v1.Image, error) {
+	bs, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal mappings, %w", err)
+	}
+
+	sig, kid, err := keys.Sign(ctx, bs)
+	if err != nil {
+		return nil, fmt.Errorf("could not sign mappings, %w", err)
+	}
+
+	return newMappingsArtifact(bs, doc.Metadata, map[string]string{
+		AnnotationSignature:    base64.StdEncoding.EncodeToString(sig),
+		AnnotationSignatureKey: kid,
+	})
+}
+
+func newMappingsArtifact(bs []byte, md MappingsMetadata, extraAnns map[string]string) (v1.Image, error) {
+	anns := map[string]string{
+		AnnotationCreated: md.Created.UTC().Format(time.RFC3339),
+	}
+	for k, v := range extraAnns {
+		anns[k] = v
+	}
+	if md.ReimageVersion != "" {
+		anns[AnnotationReimageVersion] = md.ReimageVersion
+	}
+	if md.Input != "" {
+		anns[AnnotationSource] = md.Input
+	}
+	if len(md.Sources) != 0 {
+		anns[AnnotationMappingsSources] = strings.Join(md.Sources, ",")
+	}
+
+	img, err := newArtifact(MappingsArtifactType, static.NewLayer(bs, MappingsMediaType), map[string]string{
+		"org.opencontainers.image.title": mappingsFileName,
+	}, anns, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create mappings artifact, %w", err)
+	}
+	return img, nil
+}
+
+// artifactManifest is an OCI image manifest, including the artifactType, which
+// v1.Manifest does not support
+type artifactManifest struct {
+	Subject       *v1.Descriptor    `json:"subject,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+	MediaType     types.MediaType   `json:"mediaType"`
+	ArtifactType  types.MediaType   `json:"artifactType,omitempty"`
+	Config        v1.Descriptor     `json:"config"`
+	Layers        []v1.Descriptor   `json:"layers"`
+	SchemaVersion int64             `json:"schemaVersion"`
+}
+
+// ociArtifact is an OCI 1.1 artifact, with an empty config, holding a single blob
+type ociArtifact struct {
+	blob     v1.Layer
+	manifest []byte
+}
+
+// newArtifact creates an OCI 1.1 artifact of the artifactType, holding the blob.
+// The subject is optional.
+func newArtifact(artifactType types.MediaType, blob v1.Layer, blobAnns, anns map[string]string, subject *v1.Descriptor) (v1.Image, error) {
+	cfgDig, cfgSize, err := v1.SHA256(bytes.NewReader(ociEmptyConfig))
+	if err != nil {
+		return nil, err
+	}
+	blobDesc, err := partial.Descriptor(blob)
+	if err != nil {
+		return nil, fmt.Errorf("could not describe artifact blob, %w", err)
+	}
+	blobDesc.Annotations = blobAnns
+
+	bs, err := json.Marshal(artifactManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		ArtifactType:  artifactType,
+		Config:        v1.Descriptor{MediaType: OCIEmptyMediaType, Digest: cfgDig, Size: cfgSize, Data: ociEmptyConfig},
+		Layers:        []v1.Descriptor{*blobDesc},
+		Subject:       subject,
+		Annotations:   anns,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal artifact manifest, %w", err)
+	}
+
+	return partial.CompressedToImage(&ociArtifact{blob: blob, manifest: bs})
+}
+
+func (a *ociArtifact) MediaType() (types.MediaType, error) {
+	return types.OCIManifestSchema1, nil
+}
+
+func (a *ociArtifact) RawManifest() ([]byte, error) {
+	return a.manifest, nil
+}
+
+func (a *ociArtifact) RawConfigFile() ([]byte, error) {
+	return ociEmptyConfig, nil
+}
+
+func (a *ociArtifact) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	if dig, err := a.blob.Digest(); err == nil && dig == h {
+		return a.blob, nil
+	}
+	cfg := static.NewLayer(ociEmptyConfig, OCIEmptyMediaType)
+	if dig, err := cfg.Digest(); err == nil && dig == h {
+		return cfg, nil
+	}
+	return nil, fmt.Errorf("artifact has no blob %s", h)
+}
+
+// artifactType returns the artifactType recorded in the image manifest, if any
+func artifactType(img v1.Image) (types.MediaType, error) {
+	bs, err := img.RawManifest()
+	if err != nil {
+		return "", fmt.Errorf("could not read image manifest, %w", err)
+	}
+	mf := artifactManifest{}
+	if err := json.Unmarshal(bs, &mf); err != nil {
+		return "", fmt.Errorf("could not parse image manifest, %w", err)
+	}
+	return mf.ArtifactType, nil
+}
+
+// isMappingsArtifact returns true if the image is a mappings artifact. Artifacts
+// typed by their config media type, as written by clients without artifactType
+// support, are also accepted
+func isMappingsArtifact(img v1.Image, mf *v1.Manifest) (bool, error) {
+	if mf.Config.MediaType == MappingsArtifactType {
+		return true, nil
+	}
+	at, err := artifactType(img)
+	if err != nil {
+		return false, err
+	}
+	return at == MappingsArtifactType, nil
+}
+
+// ReadMappingsArtifact returns the raw mappings document stored in an image.
+// Both artifacts created by NewMappingsArtifact, and the legacy single tar layer
+// images written by older versions of reimage are supported.
+func ReadMappingsArtifact(img v1.Image) ([]byte, error) {
+	mf, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("could not read image manifest, %w", err)
+	}
+
+	ok, err := isMappingsArtifact(img, mf)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return readMappingsBlob(img, mf)
+	}
+
+	return readLegacyMappingsLayer(img)
+}
+
+// ReadSignedMappingsArtifact returns the raw mappings document stored in a
+// mappings artifact, after verifying its signature with keys. Unsigned
+// artifacts, and legacy mappings images, are rejected.
+func ReadSignedMappingsArtifact(ctx context.Context, img v1.Image, keys Keyer) ([]byte, error) {
+	mf, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("could not read image manifest, %w", err)
+	}
+
+	ok, err := isMappingsArtifact(img, mf)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("image is not a mappings artifact, %w", ErrMappingsNotSigned)
+	}
+
+	sigStr, ok := mf.Annotations[AnnotationSignature]
+	if !ok {
+		return nil, ErrMappingsNotSigned
+	}
+	sig, err := base64.StdEncoding.DecodeString(sigStr)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode mappings signature, %w", err)
+	}
+
+	bs, err := readMappingsBlob(img, mf)
+	if err != nil {
+		return nil, err
+	}
+
+	err = keys.Verify(ctx, bs, sig)
+	if err != nil {
+		return nil, fmt.Errorf("mappings signature verification failed (signed by %q), %w", mf.Annotations[AnnotationSignatureKey], err)
+	}
+
+	return bs, nil
+}
+
+func readMappingsBlob(img v1.Image, mf *v1.Manifest) ([]byte, error) {
+	for _, l := range mf.Layers {
+		if l.MediaType != MappingsMediaType {
+			continue
+		}
+
+		ly, err := img.LayerByDigest(l.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("could not find mappings blob, %w", err)
+		}
+
+		rdr, err := ly.Compressed()
+		if err != nil {
+			return nil, fmt.Errorf("could not read mappings blob, %w", err)
+		}
+		defer rdr.Close()
+
+		return io.ReadAll(rdr)
+	}
+
+	return nil, fmt.Errorf("mappings artifact has no %s blob", MappingsMediaType)
+}
+
+func readLegacyMappingsLayer(img v1.Image) ([]byte, error) {
+	lys, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("could not read image layers, %w", err)
+	}
+	if len(lys) != 1 {
+		return nil, errors.New("multi-layer image, not from reimage")
+	}
+
+	lrdr, err := lys[0].Uncompressed()
+	if err != nil {
+		return nil, fmt.Errorf("could not read image layer, %w", err)
+	}
+	defer lrdr.Close()
+
+	tarrdr := tar.NewReader(lrdr)
+	_, err = tarrdr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("could not read image layer tar file, %w", err)
+	}
+	lbs := bytes.NewBuffer([]byte{})
+	//nolint:gosec
+	_, err = io.Copy(lbs, tarrdr)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading image layer tar content, %w", err)
+	}
+
+	return lbs.Bytes(), nil
+}
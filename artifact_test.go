@@ -0,0 +1,209 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestMappingsArtifact(t *testing.T) {
+	host := newTestRegistry(t)
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	doc := NewMappingsDocument(
+		map[string]QualifiedImage{
+			"docker.io/library/nginx:1.25": {Tag: "mirror.example.com/nginx:1.25", Digest: testDigestStr},
+		},
+		MappingsMetadata{Created: created, ReimageVersion: "v1.2.3", Input: "k8s"},
+	)
+
+	img, err := NewMappingsArtifact(doc)
+	if err != nil {
+		t.Fatalf("could not create artifact, %v", err)
+	}
+
+	ref := fmt.Sprintf("%s/test/mappings:1", host)
+	if err := crane.Push(img, ref); err != nil {
+		t.Fatal(err)
+	}
+
+	rimg, err := crane.Pull(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mf, err := rimg.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at, err := artifactType(rimg); err != nil || at != MappingsArtifactType {
+		t.Fatalf("incorrect artifact type %s, %v", at, err)
+	}
+	if mf.Config.MediaType != OCIEmptyMediaType || mf.Config.Size != 2 {
+		t.Fatalf("incorrect config %v", mf.Config)
+	}
+	if len(mf.Layers) != 1 || mf.Layers[0].MediaType != MappingsMediaType {
+		t.Fatalf("incorrect artifact layers %v", mf.Layers)
+	}
+	if mf.Annotations[AnnotationCreated] != "2024-05-01T12:00:00Z" || mf.Annotations[AnnotationReimageVersion] != "v1.2.3" || mf.Annotations[AnnotationSource] != "k8s" {
+		t.Fatalf("incorrect artifact annotations %v", mf.Annotations)
+	}
+
+	bs, err := ReadMappingsArtifact(rimg)
+	if err != nil {
+		t.Fatalf("could not read artifact, %v", err)
+	}
+	rdoc, err := ParseMappingsDocument(bs)
+	if err != nil {
+		t.Fatalf("could not parse artifact content, %v", err)
+	}
+	if rdoc.Mappings["docker.io/library/nginx:1.25"].Digest != testDigestStr {
+		t.Fatalf("incorrect mappings read from artifact, %s", bs)
+	}
+}
+
+func TestMappingsArtifact_Legacy(t *testing.T) {
+	legacy := `{"nginx:1.25":{"tag":"mirror.example.com/nginx:1.25","digest":"` + testDigestStr + `"}}`
+	img, err := crane.Image(map[string][]byte{
+		"reimage-mapping.json": []byte(legacy),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := ReadMappingsArtifact(img)
+	if err != nil {
+		t.Fatalf("could not read legacy image, %v", err)
+	}
+	if string(bs) != legacy {
+		t.Fatalf("incorrect legacy content, got %s", bs)
+	}
+}
+
+func TestMappingsArtifact_ConfigMediaType(t *testing.T) {
+	doc := NewMappingsDocument(map[string]QualifiedImage{}, MappingsMetadata{Created: time.Now()})
+	bs, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, MappingsArtifactType)
+	img, err = mutate.Append(img, mutate.Addendum{Layer: static.NewLayer(bs, MappingsMediaType)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rbs, err := ReadMappingsArtifact(img)
+	if err != nil {
+		t.Fatalf("could not read artifact, %v", err)
+	}
+	if string(rbs) != string(bs) {
+		t.Fatalf("incorrect artifact content, got %s", rbs)
+	}
+}
+
+type testKeyer struct {
+	key *ecdsa.PrivateKey
+}
+
+func newTestKeyer(t *testing.T) *testKeyer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKeyer{key: key}
+}
+
+func (tk *testKeyer) Sign(_ context.Context, bs []byte) ([]byte, string, error) {
+	digest := sha256.Sum256(bs)
+	sig, err := ecdsa.SignASN1(rand.Reader, tk.key, digest[:])
+	return sig, "test-key", err
+}
+
+func (tk *testKeyer) Verify(_ context.Context, bs []byte, sig []byte) error {
+	digest := sha256.Sum256(bs)
+	if !ecdsa.VerifyASN1(&tk.key.PublicKey, digest[:], sig) {
+		return errors.New("failed to verify signature")
+	}
+	return nil
+}
+
+func TestSignedMappingsArtifact(t *testing.T) {
+	host := newTestRegistry(t)
+
+	ctx := context.Background()
+	keys := newTestKeyer(t)
+	doc := NewMappingsDocument(
+		map[string]QualifiedImage{
+			"docker.io/library/nginx:1.25": {Tag: "mirror.example.com/nginx:1.25", Digest: testDigestStr},
+		},
+		MappingsMetadata{Created: time.Now()},
+	)
+
+	signed, err := NewSignedMappingsArtifact(ctx, doc, keys)
+	if err != nil {
+		t.Fatalf("could not create signed artifact, %v", err)
+	}
+	unsigned, err := NewMappingsArtifact(doc)
+	if err != nil {
+		t.Fatalf("could not create artifact, %v", err)
+	}
+
+	signedRef := fmt.Sprintf("%s/test/mappings:signed", host)
+	unsignedRef := fmt.Sprintf("%s/test/mappings:unsigned", host)
+	if err := crane.Push(signed, signedRef); err != nil {
+		t.Fatal(err)
+	}
+	if err := crane.Push(unsigned, unsignedRef); err != nil {
+		t.Fatal(err)
+	}
+
+	rimg, err := crane.Pull(signedRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err := ReadSignedMappingsArtifact(ctx, rimg, keys)
+	if err != nil {
+		t.Fatalf("could not verify signed artifact, %v", err)
+	}
+	if _, err := ParseMappingsDocument(bs); err != nil {
+		t.Fatalf("could not parse verified mappings, %v", err)
+	}
+
+	if _, err := ReadSignedMappingsArtifact(ctx, rimg, newTestKeyer(t)); err == nil {
+		t.Fatalf("expected verification with the wrong key to fail")
+	}
+
+	rimg, err = crane.Pull(unsignedRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadSignedMappingsArtifact(ctx, rimg, keys); !errors.Is(err, ErrMappingsNotSigned) {
+		t.Fatalf("expected unsigned artifact to be rejected, got %v", err)
+	}
+
+	legacy, err := crane.Image(map[string][]byte{"reimage-mapping.json": []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadSignedMappingsArtifact(ctx, legacy, keys); !errors.Is(err, ErrMappingsNotSigned) {
+		t.Fatalf("expected legacy image to be rejected, got %v", err)
+	}
+}
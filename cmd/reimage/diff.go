@@ -0,0 +1,144 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cerbos/reimage"
+)
+
+// parseMappingSource parses a mappings source given as an argument. Sources
+// are of the form img:REF or file:PATH, a bare PATH is treated as a file
+func parseMappingSource(str string) mappingSource {
+	for _, kind := range []string{mappingSourceImg, mappingSourceFile} {
+		if loc, ok := strings.CutPrefix(str, kind+":"); ok {
+			return mappingSource{kind: kind, loc: loc}
+		}
+	}
+	return mappingSource{kind: mappingSourceFile, loc: str}
+}
+
+type diffCmd struct {
+	failOn map[reimage.MappingChangeKind]struct{}
+	Output string
+	FailOn string
+	app
+}
+
+func setupDiff(args []string) (*diffCmd, []mappingSource, error) {
+	d := &diffCmd{}
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: reimage diff [flags] OLD NEW\n\n")
+		fmt.Fprintf(fs.Output(), "OLD and NEW are mappings sources, img:REF for registry images, or file:PATH (or just PATH) for files\n\n")
+		fs.PrintDefaults()
+	}
+
+	fs.BoolVar(&d.Debug, "debug", false, "enable debug logging")
+	fs.StringVar(&d.Output, "output", "text", "output format, (text or json)")
+	fs.StringVar(&d.FailOn, "fail-on", "", "comma separated list of kinds of change that cause a non-zero exit, (any, added, removed, retargeted, digest-changed, cves-found, cves-ignored)")
+	fs.StringVar(&d.MappingsKMSKey, "mappings-kms-key", "", "KMS key that mappings read from registry images must be signed by")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return d, nil, err
+	}
+	d.setupLog()
+
+	switch d.Output {
+	case "text", "json":
+	default:
+		return d, nil, fmt.Errorf("invalid output format, should be text or json")
+	}
+
+	d.failOn = map[reimage.MappingChangeKind]struct{}{}
+	for _, str := range strings.Split(d.FailOn, ",") {
+		str = strings.TrimSpace(str)
+		switch str {
+		case "":
+		case "any":
+			for _, k := range reimage.MappingChangeKinds {
+				d.failOn[k] = struct{}{}
+			}
+		default:
+			k, err := reimage.ParseMappingChangeKind(str)
+			if err != nil {
+				return d, nil, err
+			}
+			d.failOn[k] = struct{}{}
+		}
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return d, nil, fmt.Errorf("expected 2 mappings sources, got %d", fs.NArg())
+	}
+
+	return d, []mappingSource{parseMappingSource(fs.Arg(0)), parseMappingSource(fs.Arg(1))}, nil
+}
+
+func (d *diffCmd) run(ctx context.Context, w io.Writer, srcs []mappingSource) (bool, error) {
+	keys, err := d.mappingsKeyer(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	docs := make([]*reimage.MappingsDocument, len(srcs))
+	for i, src := range srcs {
+		docs[i], err = readMappingsSource(ctx, src, keys)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	changes, err := reimage.DiffMappings(docs[0].Mappings, docs[1].Mappings)
+	if err != nil {
+		return false, err
+	}
+
+	if d.Output == "json" {
+		err = reimage.WriteMappingsDiffJSON(w, changes)
+	} else {
+		err = reimage.WriteMappingsDiffText(w, changes)
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not write diff, %w", err)
+	}
+
+	for _, c := range changes {
+		if _, ok := d.failOn[c.Kind]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// diffMain implements the diff command, it returns the exit code
+func diffMain(args []string) int {
+	d, srcs, err := setupDiff(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		d.setupLog().Error(fmt.Errorf("invalid options, %w", err).Error())
+		return exitError
+	}
+
+	failed, err := d.run(context.Background(), os.Stdout, srcs)
+	if err != nil {
+		d.log.Error(fmt.Errorf("diff failed, %w", err).Error())
+		return exitError
+	}
+	if failed {
+		return exitChanged
+	}
+	return 0
+}
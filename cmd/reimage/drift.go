@@ -0,0 +1,125 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cerbos/reimage"
+)
+
+type driftCmd struct {
+	Output string
+	app
+	Concurrency int
+	FailOnDrift bool
+}
+
+func setupDrift(args []string) (*driftCmd, mappingSource, error) {
+	d := &driftCmd{}
+	fs := flag.NewFlagSet("drift", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: reimage drift [flags] SOURCE\n\n")
+		fmt.Fprintf(fs.Output(), "SOURCE is a mappings source, img:REF for registry images, or file:PATH (or just PATH) for files\n\n")
+		fs.PrintDefaults()
+	}
+
+	fs.BoolVar(&d.Debug, "debug", false, "enable debug logging")
+	fs.BoolVar(&d.DryRun, "dryrun", false, "only log actions")
+	fs.StringVar(&d.Output, "output", "text", "output format, (text or json)")
+	fs.BoolVar(&d.FailOnDrift, "fail-on-drift", false, "exit with a non-zero status if any images have drifted")
+	fs.IntVar(&d.Concurrency, "concurrency", 10, "maximum number of images to resolve at once (0 for unlimited)")
+	fs.StringVar(&d.MappingsKMSKey, "mappings-kms-key", "", "KMS key that mappings read from registry images must be signed by, and that updated mappings images are signed with")
+	fs.StringVar(&d.WriteMappings, "write-json-mappings-file", "", "write the mappings, updated with the current upstream digests, to a json file")
+	fs.StringVar(&d.WriteMappingsImg, "write-json-mappings-img", "", "write the mappings, updated with the current upstream digests, to a registry image")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return d, mappingSource{}, err
+	}
+	d.setupLog()
+
+	switch d.Output {
+	case "text", "json":
+	default:
+		return d, mappingSource{}, fmt.Errorf("invalid output format, should be text or json")
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return d, mappingSource{}, fmt.Errorf("expected 1 mappings source, got %d", fs.NArg())
+	}
+
+	src := parseMappingSource(fs.Arg(0))
+	d.Input = "drift"
+	d.staticSources = []mappingSource{src}
+
+	return d, src, nil
+}
+
+func (d *driftCmd) run(ctx context.Context, w io.Writer, src mappingSource) (bool, error) {
+	keys, err := d.mappingsKeyer(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	doc, err := readMappingsSource(ctx, src, keys)
+	if err != nil {
+		return false, err
+	}
+
+	drifts, err := reimage.CheckMappingsDrift(ctx, doc.Mappings, d.Concurrency)
+	if err != nil {
+		return false, err
+	}
+
+	if d.Output == "json" {
+		err = reimage.WriteMappingsDriftJSON(w, drifts)
+	} else {
+		err = reimage.WriteMappingsDriftText(w, drifts)
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not write drift report, %w", err)
+	}
+
+	if d.WriteMappings != "" || d.WriteMappingsImg != "" {
+		updated, err := reimage.UpdateDriftedMappings(doc.Mappings, drifts)
+		if err != nil {
+			return false, err
+		}
+		err = d.writeMappings(ctx, updated)
+		if err != nil {
+			return false, fmt.Errorf("failed writing mappings, %w", err)
+		}
+	}
+
+	return d.FailOnDrift && len(drifts) != 0, nil
+}
+
+// driftMain implements the drift command, it returns the exit code
+func driftMain(args []string) int {
+	d, src, err := setupDrift(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		d.setupLog().Error(fmt.Errorf("invalid options, %w", err).Error())
+		return exitError
+	}
+
+	drifted, err := d.run(context.Background(), os.Stdout, src)
+	if err != nil {
+		d.log.Error(fmt.Errorf("drift check failed, %w", err).Error())
+		return exitError
+	}
+	if drifted {
+		return exitChanged
+	}
+	return 0
+}
@@ -5,10 +5,7 @@
 package main
 
 import (
-	"archive/tar"
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -17,16 +14,14 @@ import (
 	"os"
 	"regexp"
 	"strings"
-	"sync"
 	"text/template"
 	"time"
 
 	containeranalysis "cloud.google.com/go/containeranalysis/apiv1"
 	kms "cloud.google.com/go/kms/apiv1"
+	"github.com/Masterminds/semver/v3"
 	"github.com/buildkite/shellwords"
 	"github.com/cerbos/reimage"
-	"github.com/google/go-containerregistry/pkg/crane"
-	"github.com/google/go-containerregistry/pkg/name"
 	"google.golang.org/api/binaryauthorization/v1"
 
 	"k8s.io/apimachinery/pkg/util/yaml"
@@ -35,43 +30,94 @@ import (
 type inputFn func(io.Writer, io.Reader, reimage.Updater) error
 
 type app struct {
-	imagFinder            reimage.ImagesFinder
-	remoteTemplate        *template.Template
-	log                   *slog.Logger
-	vulnCheckIgnoreImages *regexp.Regexp
-	inputFn               inputFn
-	static                *reimage.StaticRemapper
-	ignore                *regexp.Regexp
-	renameIgnore          *regexp.Regexp
-	WriteMappingsImg      string
-	VulnCheckIgnoreImages string
-	RenameRemotePath      string
-	GCPKMSKey             string
-	BinAuthzAttestor      string
-	VulnCheckMethod       string
-	RulesConfigFile       string
-	RenameIgnore          string
-	Input                 string
-	WriteMappings         string
-	RenameTemplateString  string
-	StaticMappings        string
-	StaticMappingsImg     string
-	Ignore                string
-	TrivyCommand          string
-	GrafeasParent         string
-	trivyCommand          []string
-	VulnCheckIgnoreList   []string
-	VulnCheckMaxCVSS      float64
-	VulnCheckTimeout      time.Duration
-	VulnCheckMaxRetries   int
-	Version               bool
-	VerifyStaticMappings  bool
-	DryRun                bool
-	NoCopy                bool
-	Clobber               bool
-	RenameForceToDigest   bool
-	Debug                 bool
-	MappingsOnly          bool
+	imagFinder                 reimage.ImagesFinder
+	remoteTemplate             *template.Template
+	log                        *slog.Logger
+	vulnCheckIgnoreImages      *regexp.Regexp
+	inputFn                    inputFn
+	static                     *reimage.StaticRemapper
+	ignore                     *regexp.Regexp
+	renameIgnore               *regexp.Regexp
+	semverPolicy               reimage.SemverPolicy
+	tracer                     *reimage.TracingRemapper
+	mappingsKeys               reimage.Keyer
+	vulnCheckKEV               *reimage.KEVCatalog
+	vulnCheckEPSS              *reimage.EPSSScores
+	VulnCheckIgnoreFile        string
+	VulnCheckVEXFiles          string
+	VulnCheckCacheDir          string
+	VulnCheckMaxSeverity       string
+	VulnCheckUnscored          string
+	VulnCheckCVSSSources       string
+	VulnCheckCVSSVersion       string
+	vulnCheckUnscored          reimage.UnscoredPolicy
+	vulnCheckExploited         reimage.ExploitedPolicy
+	imageAgePolicy             reimage.ImageAgePolicy
+	VulnCheckSARIFFile         string
+	VulnCheckJUnitFile         string
+	VulnCheckKEVFile           string
+	VulnCheckEPSSFile          string
+	VulnCheckExploited         string
+	ImageAgePolicy             string
+	WriteMappingsImg           string
+	VulnCheckIgnoreImages      string
+	RenameRemotePath           string
+	GCPKMSKey                  string
+	MappingsKMSKey             string
+	BinAuthzAttestor           string
+	VulnCheckMethod            string
+	RulesConfigFile            string
+	RenameIgnore               string
+	Input                      string
+	WriteMappings              string
+	RenameTemplateString       string
+	RenameRulesFile            string
+	SemverResolveImages        string
+	SemverConstraint           string
+	MappingsKeyStyle           string
+	Explain                    string
+	ExplainFile                string
+	Ignore                     string
+	TrivyCommand               string
+	TrivyServer                string
+	GrypeCommand               string
+	GrafeasParent              string
+	trivyCommand               []string
+	grypeCommand               []string
+	VulnCheckIgnoreList        []string
+	rewriteRules               []reimage.RewriteRule
+	staticSources              []mappingSource
+	vulnCheckCVSS              reimage.CVSSPreference
+	vulnCheckExceptions        []reimage.CVEException
+	vulnCheckExcCfgs           []reimage.CVEExceptionConfig
+	VulnCheckMaxCVSS           float64
+	VulnCheckMinEPSS           float64
+	VulnCheckTimeout           time.Duration
+	VulnCheckNewCVEGrace       time.Duration
+	VulnCheckCacheTTL          time.Duration
+	TrivyTimeout               time.Duration
+	MaxImageAge                time.Duration
+	MaxBaseImageAge            time.Duration
+	VulnCheckMaxRetries        int
+	VulnCheckConcurrency       int
+	AttestConcurrency          int
+	AgeCheckConcurrency        int
+	mappingsKeyStyle           reimage.RefStyle
+	vulnCheckMaxSeverity       reimage.Severity
+	Version                    bool
+	VerifyStaticMappings       bool
+	DryRun                     bool
+	NoCopy                     bool
+	Clobber                    bool
+	RenameForceToDigest        bool
+	RenameKeepTag              bool
+	Debug                      bool
+	MappingsOnly               bool
+	StaticMappingsStrict       bool
+	StaticMappingsAllowMissing bool
+	VulnCheckVEXReferrers      bool
+	VulnCheckFixableOnly       bool
+	VulnCheckCacheRefs         bool
 }
 
 func setup() (*app, error) {
@@ -81,6 +127,8 @@ func setup() (*app, error) {
 	flag.BoolVar(&a.Version, "V", false, "print version/build info")
 	flag.BoolVar(&a.DryRun, "dryrun", false, "only log actions")
 	flag.BoolVar(&a.Debug, "debug", false, "enable debug logging")
+	flag.StringVar(&a.Explain, "explain", "", "print every remapping decision for each image, (text or json)")
+	flag.StringVar(&a.ExplainFile, "explain-file", "", "write the explain output to a file rather than stderr")
 
 	flag.StringVar(&a.Input, "input", "k8s", "type of input, (k8s or yaml)")
 	flag.StringVar(&a.RulesConfigFile, "rules-config", "", "yaml definition of kind/image-path mappings, (kind: raw for raw yaml input rules)")
@@ -92,28 +140,64 @@ func setup() (*app, error) {
 	flag.StringVar(&a.RenameIgnore, "rename-ignore", "^$", "do not rename images matching this expression (may still be converted to digest form")
 	flag.StringVar(&a.RenameRemotePath, "rename-remote-path", "", "template for remapping imported images")
 	flag.StringVar(&a.RenameTemplateString, "rename-template", reimage.DefaultTemplateStr, "template for remapping imported images")
+	flag.StringVar(&a.RenameRulesFile, "rename-rules-file", "", "yaml list of ordered prefix/regexp rewrite rules for renaming images (replaces rename-template)")
 	flag.BoolVar(&a.RenameForceToDigest, "rename-force-digest", false, "the final renamed image will be transformed to digest form before output")
+	flag.BoolVar(&a.RenameKeepTag, "rename-keep-tag", false, "when forcing digests, keep the tag alongside the digest (repo:tag@sha256:...)")
+
+	flag.StringVar(&a.SemverResolveImages, "semver-resolve-images", "", "resolve partial version tags (e.g. nginx:1.25) of images matching this expression to the highest matching version tag")
+	flag.StringVar(&a.SemverConstraint, "semver-constraint", "", "semver constraint for resolving tags, (default is derived from the requested tag, e.g. 1.25 resolves to ~1.25)")
 
 	flag.BoolVar(&a.Clobber, "clobber", false, "allow overwriting remote images")
 	flag.BoolVar(&a.NoCopy, "no-copy", false, "disable copying of renamed images")
 
 	flag.StringVar(&a.WriteMappings, "write-json-mappings-file", "", "write final image mappings to a json file")
 	flag.StringVar(&a.WriteMappingsImg, "write-json-mappings-img", "", "write final image mapping to a registry image")
-	flag.StringVar(&a.StaticMappings, "static-json-mappings-file", "", "take all mappings from a mappings file")
-	flag.StringVar(&a.StaticMappingsImg, "static-json-mappings-img", "", "take all mapping from a mappings registry image")
+	flag.Var(mappingSourcesFlag{kind: mappingSourceFile, sources: &a.staticSources}, "static-json-mappings-file", "take mappings from a mappings file (may be repeated, earlier sources take precedence)")
+	flag.Var(mappingSourcesFlag{kind: mappingSourceImg, sources: &a.staticSources}, "static-json-mappings-img", "take mappings from a mappings registry image (may be repeated, earlier sources take precedence)")
+	flag.BoolVar(&a.StaticMappingsAllowMissing, "static-json-mappings-allow-missing", false, "images not in the static mappings are renamed as normal, rather than failing")
+	flag.BoolVar(&a.StaticMappingsStrict, "static-json-mappings-strict", false, "fail if static mapping sources map the same image differently, rather than logging a warning")
+	flag.StringVar(&a.MappingsKMSKey, "mappings-kms-key", "", "KMS key used to sign mappings written to registry images, mappings read from registry images must be signed by this key (e.g. projects/PROJECT/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY/cryptoKeyVersions/V)")
+	flag.StringVar(&a.MappingsKeyStyle, "mappings-key-style", "full", "style of the image names used as keys in written mappings, (full, e.g. docker.io/library/nginx:latest, or short, e.g. nginx:latest)")
 
 	flag.DurationVar(&a.VulnCheckTimeout, "vulncheck-timeout", 10*time.Minute, "how long to wait for vulnerability scanning to complete")
+	flag.StringVar(&a.VulnCheckSARIFFile, "vulncheck-sarif-file", "", "write a SARIF report of the vulnerabilities found in each image to this file")
+	flag.StringVar(&a.VulnCheckJUnitFile, "vulncheck-junit-file", "", "write a JUnit XML report, with a test case for each image checked, to this file")
+	flag.StringVar(&a.VulnCheckKEVFile, "vulncheck-kev-file", "", "known exploited vulnerabilities catalog, in CISA's JSON format, listed vulnerabilities fail the check regardless of their score")
+	flag.StringVar(&a.VulnCheckEPSSFile, "vulncheck-epss-file", "", "EPSS scores, in FIRST's CSV format (optionally gzipped), vulnerabilities scoring at least vulncheck-min-epss are treated as exploited")
+	flag.Float64Var(&a.VulnCheckMinEPSS, "vulncheck-min-epss", 0, "EPSS probability at which vulnerabilities are treated as exploited (e.g. 0.5)")
+	flag.StringVar(&a.VulnCheckExploited, "vulncheck-exploited", "fail", "how to treat exploited vulnerabilities, (fail, or warn to only record them)")
+	flag.DurationVar(&a.MaxImageAge, "max-image-age", 0, "maximum age of images, by their created time (e.g. 8760h), not checked if 0")
+	flag.DurationVar(&a.MaxBaseImageAge, "max-base-image-age", 0, "maximum age of the base images recorded in the images' OCI annotations, not checked if 0")
+	flag.StringVar(&a.ImageAgePolicy, "image-age-policy", "fail", "how to treat images that are too old, (fail, or warn to only log them)")
+	flag.IntVar(&a.AgeCheckConcurrency, "age-check-concurrency", 4, "max number of images to check the age of at once (0 for unlimited)")
+	flag.IntVar(&a.VulnCheckConcurrency, "vulncheck-concurrency", 4, "max number of images to check for vulnerabilities at once (0 for unlimited)")
 	flag.IntVar(&a.VulnCheckMaxRetries, "vulncheck-max-retries", 20, "max number of attempts to check for vulnerabilitie")
 	flag.StringVar(&vulnIgnoreStr, "vulncheck-ignore-cve-list", "", "comma separated list of vulnerabilities to ignore")
+	flag.StringVar(&a.VulnCheckIgnoreFile, "vulncheck-ignore-cve-file", "", "yaml list of vulnerability exceptions, each with an id, justification, and optional images regexp and expiry date")
+	flag.StringVar(&a.VulnCheckVEXFiles, "vulncheck-vex-files", "", "comma separated list of OpenVEX documents, vulnerabilities that are not_affected or fixed in the checked image are ignored")
+	flag.BoolVar(&a.VulnCheckVEXReferrers, "vulncheck-vex-referrers", false, "read OpenVEX documents attached to the checked images as OCI referrers")
 	flag.Float64Var(&a.VulnCheckMaxCVSS, "vulncheck-max-cvss", 0.0, "maximum CVSS vulnerabitility score")
-	flag.StringVar(&a.VulnCheckIgnoreImages, "vulncheck-ignore-images", "", "regexp of images to skip for CVE checks")
-	flag.StringVar(&a.VulnCheckMethod, "vulncheck-method", "trivy", "force the vulnerability check method, (trivy or grafeas)")
+	flag.StringVar(&a.VulnCheckMaxSeverity, "vulncheck-max-severity", "", "maximum vulnerability severity, (low, medium, high or critical)")
+	flag.StringVar(&a.VulnCheckUnscored, "vulncheck-unscored", "pass", "how to treat vulnerabilities with no CVSS score, (pass, fail, or severity to use the lowest score for their severity)")
+	flag.StringVar(&a.VulnCheckCVSSSources, "vulncheck-cvss-sources", "", "comma separated list of sources of CVSS scores and severities, in order of preference (e.g. nvd,vendor), by default the highest score is used")
+	flag.StringVar(&a.VulnCheckCVSSVersion, "vulncheck-cvss-version", "", "CVSS version of scores to use, (v3 or v2), by default v3 scores are used if available")
+	flag.BoolVar(&a.VulnCheckFixableOnly, "vulncheck-fixable-only", false, "only fail on vulnerabilities that have a fix available, others are recorded as found")
+	flag.DurationVar(&a.VulnCheckNewCVEGrace, "vulncheck-new-cve-grace-period", 0, "vulnerabilities published more recently than this are recorded as found, rather than failing (e.g. 168h), requires the trivy or stored vulncheck method")
+	flag.StringVar(&a.VulnCheckCacheDir, "vulncheck-cache-dir", "", "cache vulnerability scan results in this directory")
+	flag.BoolVar(&a.VulnCheckCacheRefs, "vulncheck-cache-referrers", false, "cache vulnerability scan results as OCI referrers attached to the scanned images")
+	flag.DurationVar(&a.VulnCheckCacheTTL, "vulncheck-cache-ttl", 24*time.Hour, "how long cached vulnerability scan results are used for (0 to use them forever)")
+	flag.StringVar(&a.VulnCheckIgnoreImages, "vulncheck-ignore-images", "", "regexp of images to skip for CVE and age checks")
+	flag.StringVar(&a.VulnCheckMethod, "vulncheck-method", "trivy", "force the vulnerability check method, (trivy, grype, grafeas, or stored to re-check the results recorded in the static mappings)")
 
 	flag.StringVar(&a.GrafeasParent, "grafeas-parent", "", "value for the parent of the grafeas client (e.g. \"project/my-project-id\" for GCP")
 
 	flag.StringVar(&a.TrivyCommand, "trivy-command", "trivy image -f json", "the command to run to retrieve vulnerability scans in trivy's JSON format (the image id will be added as an additional arg")
+	flag.StringVar(&a.TrivyServer, "trivy-server", "", "URL of a trivy server to scan images with, using trivy's client/server mode")
+	flag.DurationVar(&a.TrivyTimeout, "trivy-timeout", 0, "timeout passed to trivy for each scan (trivy's default is used if 0)")
+	flag.StringVar(&a.GrypeCommand, "grype-command", "grype -o json", "the command to run to retrieve vulnerability scans in grype's JSON format (the image id will be added as an additional arg)")
 
 	flag.StringVar(&a.BinAuthzAttestor, "binauthz-attestor", "", "Google BinAuthz Attestor (e.g. projects/myproj/attestors/myattestor)")
+	flag.IntVar(&a.AttestConcurrency, "attest-concurrency", 4, "max number of images to attest at once (0 for unlimited)")
 
 	flag.StringVar(&a.GCPKMSKey, "gcp-kms-key", "", "KMS key, defaults to the first key listed in the binauthz attestation (e.g. projects/PROJECT/locations/LOCATION/keyRings/KEYRING/cryptoKeys/KEY/cryptoKeyVersions/V)")
 
@@ -149,31 +233,92 @@ func setup() (*app, error) {
 		a.vulnCheckIgnoreImages = regexp.MustCompile(a.VulnCheckIgnoreImages)
 	}
 
+	a.mappingsKeyStyle, err = reimage.ParseRefStyle(a.MappingsKeyStyle)
+	if err != nil {
+		return &a, err
+	}
+
+	if a.VulnCheckMaxSeverity != "" {
+		a.vulnCheckMaxSeverity, err = reimage.ParseSeverity(a.VulnCheckMaxSeverity)
+		if err != nil {
+			return &a, fmt.Errorf("could not parse max severity, %w", err)
+		}
+	}
+
+	a.vulnCheckUnscored, err = reimage.ParseUnscoredPolicy(a.VulnCheckUnscored)
+	if err != nil {
+		return &a, err
+	}
+
+	a.vulnCheckExploited, err = reimage.ParseExploitedPolicy(a.VulnCheckExploited)
+	if err != nil {
+		return &a, err
+	}
+
+	// only trivy reports when vulnerabilities were published, (and the stored
+	// results recorded from it), the grace period would do nothing otherwise
+	if a.VulnCheckNewCVEGrace != 0 && a.VulnCheckMethod != "trivy" && a.VulnCheckMethod != "stored" {
+		return &a, fmt.Errorf("vulncheck-new-cve-grace-period requires vulnerability publication dates, which the %s vulncheck method does not report", a.VulnCheckMethod)
+	}
+
+	a.imageAgePolicy, err = reimage.ParseImageAgePolicy(a.ImageAgePolicy)
+	if err != nil {
+		return &a, err
+	}
+
+	for _, str := range strings.Split(a.VulnCheckCVSSSources, ",") {
+		str = strings.TrimSpace(str)
+		if str == "" {
+			continue
+		}
+		a.vulnCheckCVSS.Sources = append(a.vulnCheckCVSS.Sources, strings.ToLower(str))
+	}
+
+	a.vulnCheckCVSS.Version, err = reimage.ParseCVSSVersion(a.VulnCheckCVSSVersion)
+	if err != nil {
+		return &a, err
+	}
+
+	if a.SemverResolveImages != "" {
+		a.semverPolicy.Images = regexp.MustCompile(a.SemverResolveImages)
+	}
+
+	if a.SemverConstraint != "" {
+		a.semverPolicy.Constraint, err = semver.NewConstraint(a.SemverConstraint)
+		if err != nil {
+			return &a, fmt.Errorf("could not parse semver constraint, %w", err)
+		}
+	}
+
 	// What follows is horrid, and probably a sign of some abstraction breakdown
 	// But basically, if static mapping was specified, we disable/ignore
-	// the rename mapping
-	if a.StaticMappings != "" || a.StaticMappingsImg != "" {
-		if a.StaticMappings != "" && a.StaticMappingsImg != "" {
-			return &a, fmt.Errorf("only one static mappings configuration is allowed")
-		}
-		if a.RenameRemotePath != "" || a.RenameTemplateString != reimage.DefaultTemplateStr {
+	// the rename mapping, unless it is needed for images missing from
+	// the static mappings
+	if len(a.staticSources) != 0 && !a.StaticMappingsAllowMissing {
+		if a.RenameRemotePath != "" || a.RenameTemplateString != reimage.DefaultTemplateStr || a.RenameRulesFile != "" {
 			log.Info("settings static mappings disables image renaming ")
 			a.RenameRemotePath = ""
 			a.RenameTemplateString = ""
+			a.RenameRulesFile = ""
 		}
 	}
 
-	if a.MappingsOnly && (a.StaticMappings == "" && a.StaticMappingsImg == "") {
+	if a.MappingsOnly && len(a.staticSources) == 0 {
 		return &a, fmt.Errorf("mappings-only requested, but no static mapping file of image specified")
 	}
 
-	if a.RenameRemotePath != "" && a.RenameTemplateString != "" {
-		a.remoteTemplate, err = template.New("remote").Parse(a.RenameTemplateString)
+	if a.RenameRulesFile != "" {
+		err = a.setupRewriteRules()
+		if err != nil {
+			return &a, err
+		}
+	} else if a.RenameRemotePath != "" && a.RenameTemplateString != "" {
+		a.remoteTemplate, err = template.New("remote").Funcs(reimage.TemplateFuncs).Parse(a.RenameTemplateString)
 		if err != nil {
 			return &a, fmt.Errorf("failed parsing remote template, %w", err)
 		}
-	} else if a.StaticMappings == "" && a.StaticMappingsImg == "" {
-		log.Info("copying disabled, (remote path and remote template must be set)")
+	} else if len(a.staticSources) == 0 {
+		log.Info("copying disabled, (remote path and remote template, or rename rules, must be set)")
 	}
 
 	err = a.setupRulesConfigs()
@@ -181,11 +326,39 @@ func setup() (*app, error) {
 		return &a, err
 	}
 
+	if a.VulnCheckIgnoreFile != "" {
+		err = a.setupCVEExceptions()
+		if err != nil {
+			return &a, err
+		}
+	}
+
+	err = a.setupExploitData()
+	if err != nil {
+		return &a, err
+	}
+
 	a.trivyCommand, err = shellwords.Split(a.TrivyCommand)
 	if err != nil {
 		return &a, fmt.Errorf("could not parse trivy command, %w", err)
 	}
 
+	a.grypeCommand, err = shellwords.Split(a.GrypeCommand)
+	if err != nil {
+		return &a, fmt.Errorf("could not parse grype command, %w", err)
+	}
+
+	if a.renamesBySource() && (a.WriteMappings != "" || a.WriteMappingsImg != "" ||
+		a.vulnCheckEnabled() || a.MaxImageAge != 0 || a.MaxBaseImageAge != 0 || a.BinAuthzAttestor != "") {
+		return &a, errors.New("rename templates using .Kind, .Namespace, .Name or .Container cannot be used when mappings are written, checked, or attested")
+	}
+
+	switch a.Explain {
+	case "", "text", "json":
+	default:
+		return &a, fmt.Errorf("invalid explain format, should be text or json")
+	}
+
 	switch a.Input {
 	case "k8s":
 		a.inputFn = reimage.ProcessK8s
@@ -222,104 +395,168 @@ func (a *app) setupRulesConfigs() error {
 	return nil
 }
 
-func readStaticMappingsImage(src string) ([]byte, error) {
-	rimg, err := crane.Pull(src)
+func (a *app) setupRewriteRules() error {
+	bs, err := os.ReadFile(a.RenameRulesFile)
 	if err != nil {
-		return nil, fmt.Errorf("image pull failed, %w", err)
+		return fmt.Errorf("failed reading rename rules, %w", err)
 	}
 
-	lys, err := rimg.Layers()
+	var cfgs []reimage.RewriteRuleConfig
+	err = yaml.Unmarshal(bs, &cfgs)
 	if err != nil {
-		return nil, fmt.Errorf("could not read image layers, %w", err)
-	}
-	if len(lys) != 1 {
-		return nil, errors.New("multi-layer image, not from reimage")
+		return fmt.Errorf("could not parse rename rules, %w", err)
 	}
 
-	lrdr, err := lys[0].Uncompressed()
+	a.rewriteRules, err = reimage.CompileRewriteRules(cfgs)
 	if err != nil {
-		return nil, fmt.Errorf("could not read image layer, %w", err)
+		return fmt.Errorf("could not compile rename rules, %w", err)
 	}
+	return nil
+}
 
-	tarrdr := tar.NewReader(lrdr)
-	_, err = tarrdr.Next()
+func (a *app) setupCVEExceptions() error {
+	bs, err := os.ReadFile(a.VulnCheckIgnoreFile)
 	if err != nil {
-		return nil, fmt.Errorf("could not read image layer tar file, %w", err)
+		return fmt.Errorf("failed reading cve exceptions, %w", err)
 	}
-	lbs := bytes.NewBuffer([]byte{})
-	//nolint:gosec
-	_, err = io.Copy(lbs, tarrdr)
+
+	err = yaml.Unmarshal(bs, &a.vulnCheckExcCfgs)
 	if err != nil {
-		return nil, fmt.Errorf("failed reading image layer tar content, %w", err)
+		return fmt.Errorf("could not parse cve exceptions, %w", err)
 	}
 
-	return lbs.Bytes(), nil
-}
+	a.vulnCheckExceptions, err = reimage.CompileCVEExceptions(a.vulnCheckExcCfgs)
+	if err != nil {
+		return fmt.Errorf("could not compile cve exceptions, %w", err)
+	}
 
-func readStaticMappingsFile(src string) ([]byte, error) {
-	return os.ReadFile(src)
+	for _, e := range reimage.ExpiredCVEExceptions(a.vulnCheckExceptions, time.Now()) {
+		a.log.Warn("cve exception has expired", "exception", e.String())
+	}
+	return nil
 }
 
-func (a *app) readStaticMappings(confirmDigests bool) (*reimage.StaticRemapper, error) {
-	var bs []byte
-	var err error
-	switch {
-	case a.StaticMappings != "":
-		bs, err = readStaticMappingsFile(a.StaticMappings)
-	case a.StaticMappingsImg != "":
-		bs, err = readStaticMappingsImage(a.StaticMappingsImg)
-	default:
-		return nil, nil
+// setupExploitData loads the known exploited vulnerabilities catalog, and EPSS
+// scores, if they are configured
+func (a *app) setupExploitData() error {
+	if a.VulnCheckKEVFile != "" {
+		bs, err := os.ReadFile(a.VulnCheckKEVFile)
+		if err != nil {
+			return fmt.Errorf("failed reading kev catalog, %w", err)
+		}
+		a.vulnCheckKEV, err = reimage.ParseKEVCatalog(bs)
+		if err != nil {
+			return err
+		}
+		a.log.Debug("loaded kev catalog", "version", a.vulnCheckKEV.CatalogVersion, "count", len(a.vulnCheckKEV.Vulnerabilities))
 	}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed reading json mappings, %w", err)
+	if a.VulnCheckEPSSFile != "" {
+		if a.VulnCheckMinEPSS == 0 {
+			return errors.New("vulncheck-epss-file requires vulncheck-min-epss")
+		}
+		f, err := os.Open(a.VulnCheckEPSSFile)
+		if err != nil {
+			return fmt.Errorf("failed reading epss scores, %w", err)
+		}
+		defer f.Close()
+		a.vulnCheckEPSS, err = reimage.ParseEPSSScores(f)
+		if err != nil {
+			return err
+		}
+		a.log.Debug("loaded epss scores", "model", a.vulnCheckEPSS.ModelVersion, "date", a.vulnCheckEPSS.ScoreDate)
 	}
 
-	rimgs := map[string]reimage.QualifiedImage{}
-	err = json.Unmarshal(bs, &rimgs)
-	if err != nil {
-		return nil, fmt.Errorf("could not parse as JSON map, %w", err)
-	}
-	return reimage.NewStaticRemapper(rimgs, confirmDigests)
+	return nil
 }
 
-func (a *app) writeMappings(mappings map[string]reimage.QualifiedImage) (err error) {
-	bs, err := json.Marshal(mappings)
-	if err != nil {
-		return fmt.Errorf("could not marshal mappings, %w", err)
-	}
+// renamesBySource returns true if the rename template can rename the same image
+// differently for each object, or container, it is found in. Mappings are keyed
+// by image, so cannot be recorded for such templates
+func (a *app) renamesBySource() bool {
+	return a.remoteTemplate != nil && reimage.TemplateUsesSource(a.remoteTemplate)
+}
 
-	if a.DryRun {
-		a.log.Info("dry-run, will not write static mappings file")
-		return nil
+// vulnCheckEnabled returns true if a vulnerability policy has been set
+func (a *app) vulnCheckEnabled() bool {
+	return a.VulnCheckMaxCVSS != 0 ||
+		a.vulnCheckMaxSeverity != reimage.SeverityUnknown ||
+		a.vulnCheckKEV != nil ||
+		a.vulnCheckEPSS != nil
+}
+
+// cacheVulnGetter wraps the vget in a cache, if one is configured
+func (a *app) cacheVulnGetter(vget reimage.VulnGetter) (reimage.VulnGetter, error) {
+	var cache reimage.VulnCache
+	switch {
+	case a.VulnCheckCacheDir != "" && a.VulnCheckCacheRefs:
+		return nil, errors.New("only one of vulncheck-cache-dir or vulncheck-cache-referrers may be set")
+	case a.VulnCheckCacheDir != "":
+		cache = &reimage.DirVulnCache{Dir: a.VulnCheckCacheDir}
+	case a.VulnCheckCacheRefs:
+		cache = &reimage.ReferrersVulnCache{}
+	default:
+		return vget, nil
 	}
 
-	a.log.Info("writing mappings file", "file", a.WriteMappings)
-	if a.WriteMappings != "" {
-		a.log.Info("writing mappings file", "file", a.WriteMappings)
-		err = os.WriteFile(a.WriteMappings, bs, 0600)
-		if err != nil {
-			return fmt.Errorf("could not write file, %w", err)
+	var scanner string
+	switch a.VulnCheckMethod {
+	case "trivy":
+		scanner = a.TrivyCommand
+		if a.TrivyServer != "" {
+			scanner += " --server " + a.TrivyServer
 		}
-	}
+	case "grype":
+		scanner = a.GrypeCommand
+	case "grafeas":
+		scanner = a.GrafeasParent
+	default:
+		// there's nothing to gain from caching stored results
+		return vget, nil
+	}
+
+	return &reimage.CachingVulnGetter{
+		Getter: vget,
+		Cache:  cache,
+		Logger: a.log,
+		// scores and severities are chosen when scanning, so the preferences
+		// are part of the scanner's identity
+		Scanner: fmt.Sprintf("%s:%s cvss:%s/%s", a.VulnCheckMethod, scanner, strings.Join(a.vulnCheckCVSS.Sources, ","), a.vulnCheckCVSS.Version),
+		TTL:     a.VulnCheckCacheTTL,
+	}, nil
+}
+
+func (a *app) vexGetter() (reimage.VEXGetter, error) {
+	var vex reimage.MultiVEXGetter
 
-	if a.WriteMappingsImg != "" {
-		cnt := map[string][]byte{
-			"reimage-mapping.json": bs,
+	static := &reimage.StaticVEXGetter{}
+	for _, fn := range strings.Split(a.VulnCheckVEXFiles, ",") {
+		fn = strings.TrimSpace(fn)
+		if fn == "" {
+			continue
 		}
-		img, err := crane.Image(cnt)
+		bs, err := os.ReadFile(fn)
 		if err != nil {
-			return fmt.Errorf("could not create image, %w", err)
+			return nil, fmt.Errorf("failed reading vex document, %w", err)
 		}
-
-		err = crane.Push(img, a.WriteMappingsImg)
+		doc, err := reimage.ParseVEXDocument(bs)
 		if err != nil {
-			return fmt.Errorf("could not push image, %w", err)
+			return nil, fmt.Errorf("could not read %s, %w", fn, err)
 		}
+		static.Documents = append(static.Documents, doc)
+	}
+	if len(static.Documents) != 0 {
+		vex = append(vex, static)
 	}
 
-	return nil
+	if a.VulnCheckVEXReferrers {
+		vex = append(vex, &reimage.ReferrersVEXGetter{})
+	}
+
+	if len(vex) == 0 {
+		return nil, nil
+	}
+	return vex, nil
 }
 
 func (a *app) setupLog() *slog.Logger {
@@ -345,7 +582,7 @@ func (a *app) setupLog() *slog.Logger {
 	return log
 }
 
-func (a *app) buildRemapper(checkDigests bool) (reimage.Remapper, *reimage.RecorderRemapper, error) {
+func (a *app) buildRemapper(ctx context.Context, checkDigests bool) (reimage.Remapper, *reimage.RecorderRemapper, error) {
 	var err error
 	rm := reimage.MultiRemapper{}
 
@@ -353,28 +590,52 @@ func (a *app) buildRemapper(checkDigests bool) (reimage.Remapper, *reimage.Recor
 		rm = append(rm, &reimage.IgnoreRemapper{Ignore: a.ignore})
 	}
 
-	a.static, err = a.readStaticMappings(checkDigests)
+	a.static, err = a.readStaticMappings(ctx, checkDigests)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed reading static remappings, %w", err)
 	}
 
-	if a.static != nil {
-		rm = append(rm, a.static)
+	// renamers are only applied to images that are not statically mapped
+	renamers := reimage.MultiRemapper{}
+
+	if a.semverPolicy.Images != nil {
+		renamers = append(renamers, &reimage.SemverRemapper{
+			Policy: a.semverPolicy,
+			Logger: a.log,
+		})
 	}
 
-	if a.static == nil {
-		if a.remoteTemplate != nil {
-			rm = append(rm, &reimage.RenameRemapper{
-				Ignore:     a.renameIgnore,
-				RemotePath: a.RenameRemotePath,
-				RemoteTmpl: a.remoteTemplate,
-				Logger:     a.log,
-			})
-		}
+	switch {
+	case a.rewriteRules != nil:
+		renamers = append(renamers, &reimage.RewriteRemapper{
+			Ignore: a.renameIgnore,
+			Rules:  a.rewriteRules,
+			Logger: a.log,
+		})
+	case a.remoteTemplate != nil:
+		renamers = append(renamers, &reimage.RenameRemapper{
+			Ignore:     a.renameIgnore,
+			RemotePath: a.RenameRemotePath,
+			RemoteTmpl: a.remoteTemplate,
+			Logger:     a.log,
+		})
 	}
 
-	recorder := &reimage.RecorderRemapper{}
-	rm = append(rm, recorder)
+	switch {
+	case a.static == nil:
+		rm = append(rm, renamers...)
+	case a.static.AllowMissing && len(renamers) != 0:
+		a.static.Fallback = renamers
+		rm = append(rm, a.static)
+	default:
+		rm = append(rm, a.static)
+	}
+
+	var recorder *reimage.RecorderRemapper
+	if !a.renamesBySource() {
+		recorder = &reimage.RecorderRemapper{KeyStyle: a.mappingsKeyStyle}
+		rm = append(rm, recorder)
+	}
 
 	if !a.NoCopy {
 		ensurer := &reimage.EnsureRemapper{
@@ -386,102 +647,172 @@ func (a *app) buildRemapper(checkDigests bool) (reimage.Remapper, *reimage.Recor
 		rm = append(rm, ensurer)
 	}
 
+	if a.Explain != "" {
+		a.tracer = &reimage.TracingRemapper{Remapper: rm}
+		return a.tracer, recorder, nil
+	}
+
 	return rm, recorder, nil
 }
 
 // checkVulns most of this should move into the main package
 func (a *app) checkVulns(ctx context.Context, imgs map[string]reimage.QualifiedImage) error {
-	if a.VulnCheckMaxCVSS == 0 {
-		a.log.Info("skipping vulnerability checks (max CVSS is set to 0)")
+	if !a.vulnCheckEnabled() {
+		a.log.Info("skipping vulnerability checks (max CVSS is set to 0, and no max severity, or exploit data, is set)")
 		return nil
 	}
 
-	c, err := containeranalysis.NewClient(ctx)
-	if err != nil {
-		return fmt.Errorf("failed creating containeranalysis client, %w", err)
-	}
-
-	errs := make([]error, len(imgs))
-
-	wg := &sync.WaitGroup{}
-	wg.Add(len(imgs))
-
 	var vget reimage.VulnGetter
 
 	switch a.VulnCheckMethod {
 	case "trivy":
 		vget = &reimage.TrivyVulnGetter{
 			Command: a.trivyCommand,
+			CVSS:    a.vulnCheckCVSS,
+			Server:  a.TrivyServer,
+			Timeout: a.TrivyTimeout,
 		}
+	case "grype":
+		vget = &reimage.GrypeVulnGetter{
+			Command: a.grypeCommand,
+			CVSS:    a.vulnCheckCVSS,
+		}
+	case "stored":
+		if a.static == nil {
+			return errors.New("the stored vulnerability check method requires static mappings")
+		}
+		vget = reimage.NewStoredVulnGetter(a.static.Mappings)
 	case "grafeas":
+		c, err := containeranalysis.NewClient(ctx)
+		if err != nil {
+			return fmt.Errorf("failed creating containeranalysis client, %w", err)
+		}
 		gc := c.GetGrafeasClient()
 		vget = &reimage.GrafeasVulnGetter{
 			Parent:     a.GrafeasParent,
 			Grafeas:    gc,
 			RetryMax:   a.VulnCheckMaxRetries,
 			RetryDelay: a.VulnCheckTimeout,
+			CVSS:       a.vulnCheckCVSS,
 
 			Logger: a.log,
 		}
 	default:
-		return fmt.Errorf("unknown scanning method %q, should be grafeas or trivy", a.VulnCheckMethod)
+		return fmt.Errorf("unknown scanning method %q, should be grafeas, trivy, grype or stored", a.VulnCheckMethod)
+	}
+
+	vget, err := a.cacheVulnGetter(vget)
+	if err != nil {
+		return err
+	}
+
+	vex, err := a.vexGetter()
+	if err != nil {
+		return err
 	}
 
 	checker := reimage.VulnChecker{
 		Getter:        vget,
 		IgnoreImages:  a.vulnCheckIgnoreImages,
 		MaxCVSS:       float32(a.VulnCheckMaxCVSS),
+		MaxSeverity:   a.vulnCheckMaxSeverity,
+		Unscored:      a.vulnCheckUnscored,
+		KEV:           a.vulnCheckKEV,
+		EPSS:          a.vulnCheckEPSS,
+		MinEPSS:       a.VulnCheckMinEPSS,
+		Exploited:     a.vulnCheckExploited,
 		CVEIgnoreList: a.VulnCheckIgnoreList,
-	}
-
-	res := map[string]reimage.QualifiedImage{}
-	resLock := &sync.Mutex{}
+		Exceptions:    a.vulnCheckExceptions,
+		VEX:           vex,
 
-	i := 0
-	for src, img := range imgs {
-		go func(src string, img reimage.QualifiedImage, i int) {
-			defer wg.Done()
+		FixableOnly:       a.VulnCheckFixableOnly,
+		NewCVEGracePeriod: a.VulnCheckNewCVEGrace,
 
-			vcCtx, vcCancel := context.WithTimeoutCause(ctx, a.VulnCheckTimeout, errors.New("timeout waiting for vuln-check"))
-			defer vcCancel()
+		Timeout:     a.VulnCheckTimeout,
+		Concurrency: a.VulnCheckConcurrency,
+		Logger:      a.log,
+	}
 
-			a.log.Debug("start checks on", "img", img.Tag)
-			ref, err := name.ParseReference(img.Tag)
-			if err != nil {
-				errs[i] = fmt.Errorf("could not parse ref %q, %w", img, err)
-				return
+	checks, err := checker.CheckImages(ctx, imgs)
+	for src, check := range checks {
+		if check.Err != nil {
+			continue
+		}
+		for _, e := range check.Result.Expired {
+			a.log.Warn("expired cve exception no longer applies", "img", check.Image.Tag, "exception", e.String())
+		}
+		for _, cve := range check.Result.Found {
+			if cve.Exploited != "" {
+				a.log.Warn("image has exploited vulnerability", "img", check.Image.Tag, "cve", cve.ID, "exploited", cve.Exploited)
 			}
+		}
+		imgs[src] = check.Image
+	}
 
-			dig := ref.Context().Registry.Repo(ref.Context().RepositoryStr()).Digest(img.Digest)
+	if rerr := a.writeVulnReports(checks); rerr != nil {
+		return errors.Join(err, rerr)
+	}
 
-			cres, err := checker.Check(vcCtx, dig)
-			if err != nil {
-				errs[i] = fmt.Errorf("image check failed %q, %w", img, err)
-				return
-			}
+	return err
+}
 
-			resLock.Lock()
-			defer resLock.Unlock()
-			img.FoundCVEs = cres.Found
-			img.IgnoredCVEs = cres.Ignored
-			res[src] = img
-		}(src, img, i)
+// writeVulnReports writes the requested reports of the vulnerability checks
+func (a *app) writeVulnReports(checks map[string]reimage.ImageCheck) error {
+	reports := []struct {
+		write func(io.Writer, map[string]reimage.ImageCheck) error
+		file  string
+	}{
+		{file: a.VulnCheckSARIFFile, write: reimage.WriteSARIF},
+		{file: a.VulnCheckJUnitFile, write: reimage.WriteJUnit},
+	}
 
-		i++
+	for _, r := range reports {
+		if r.file == "" {
+			continue
+		}
+		f, err := os.Create(r.file)
+		if err != nil {
+			return fmt.Errorf("could not create vulnerability report, %w", err)
+		}
+		if err := r.write(f, checks); err != nil {
+			f.Close()
+			return fmt.Errorf("could not write vulnerability report %s, %w", r.file, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("could not write vulnerability report %s, %w", r.file, err)
+		}
 	}
 
-	wg.Wait()
+	return nil
+}
 
-	for _, err := range errs {
-		if errors.Is(err, context.Canceled) {
-			// if there are any context cancelled errors, we'll just return one
-			// directly
-			return err
-		}
+func (a *app) checkAges(ctx context.Context, imgs map[string]reimage.QualifiedImage) error {
+	if a.MaxImageAge == 0 && a.MaxBaseImageAge == 0 {
+		return nil
 	}
 
-	for k, v := range res {
-		imgs[k] = v
+	checker := &reimage.AgeChecker{
+		IgnoreImages: a.vulnCheckIgnoreImages,
+		MaxAge:       a.MaxImageAge,
+		MaxBaseAge:   a.MaxBaseImageAge,
+		Concurrency:  a.AgeCheckConcurrency,
+		Logger:       a.log,
+	}
+
+	checks, err := checker.CheckImages(ctx, imgs)
+	if err == nil || a.imageAgePolicy != reimage.ImageAgeWarn {
+		return err
+	}
+
+	// only images that could not be checked fail the check
+	var errs []error
+	for src, check := range checks {
+		iae := &reimage.ImageAgeError{}
+		if errors.As(check.Err, &iae) {
+			a.log.Warn("image is too old", "img", src, "error", iae.Error())
+			continue
+		}
+		errs = append(errs, check.Err)
 	}
 
 	return errors.Join(errs...)
@@ -535,57 +866,55 @@ func (a *app) attestImages(ctx context.Context, imgs map[string]reimage.Qualifie
 		Keys:    ks,
 		NoteRef: noteRef,
 		Logger:  a.log,
+
+		Concurrency: a.AttestConcurrency,
 	}
 
-	errs := make([]error, len(imgs))
+	return th.AttestImages(ctx, imgs)
+}
 
-	wg := &sync.WaitGroup{}
+func (a *app) writeExplain() error {
+	if a.tracer == nil {
+		return nil
+	}
 
-	// dedupe the digests we will sign
-	digs := map[string]name.Digest{}
-	i := 0
-	for _, img := range imgs {
-		ref, ierr := name.ParseReference(img.Tag)
-		if ierr != nil {
-			errs[i] = fmt.Errorf("could not parse ref %q, %w", img, ierr)
-			continue
+	w := io.Writer(os.Stderr)
+	if a.ExplainFile != "" {
+		f, err := os.Create(a.ExplainFile)
+		if err != nil {
+			return fmt.Errorf("could not create explain file, %w", err)
 		}
-
-		dig := ref.Context().Registry.Repo(ref.Context().RepositoryStr()).Digest(img.Digest)
-		digs[dig.String()] = dig
-		i++
-	}
-	err = errors.Join(errs...)
-	if err != nil {
-		return err
+		defer f.Close()
+		w = f
 	}
 
-	i = 0
-	errs = make([]error, len(digs))
-	wg.Add(len(digs))
-	for _, dig := range digs {
-		go func(dig name.Digest, i int) {
-			defer wg.Done()
-
-			errs[i] = th.Attest(ctx, dig)
-		}(dig, i)
-		i++
+	if a.Explain == "json" {
+		return reimage.WriteExplainJSON(w, a.tracer.Traces())
 	}
+	return reimage.WriteExplainText(w, a.tracer.Traces())
+}
 
-	wg.Wait()
-
-	for _, err := range errs {
-		if errors.Is(err, context.Canceled) {
-			// if there are any context cancelled errors, we'll just return one
-			// directly
-			return err
-		}
-	}
+// exit codes used by the sub-commands
+const (
+	exitChanged = 1 // The command found changes that were requested to be treated as failures
+	exitError   = 2
+)
 
-	return errors.Join(errs...)
+// subCommands are run in place of the default yaml processing, they return
+// the exit code
+var subCommands = map[string]func(args []string) int{
+	"diff":     diffMain,
+	"drift":    driftMain,
+	"mappings": mappingsMain,
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subCommands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+
 	var err error
 	app, err := setup()
 	if err != nil {
@@ -595,8 +924,10 @@ func main() {
 
 	app.log.Debug("reimage started")
 
+	ctx := context.Background()
+
 	var mappings map[string]reimage.QualifiedImage
-	rm, recorder, err := app.buildRemapper(app.VerifyStaticMappings)
+	rm, recorder, err := app.buildRemapper(ctx, app.VerifyStaticMappings)
 	if err != nil {
 		app.log.Error(err.Error())
 		os.Exit(1)
@@ -605,13 +936,16 @@ func main() {
 	if !app.MappingsOnly {
 		s := &reimage.RenameUpdater{
 			Ignore:       app.ignore,
+			Tracer:       app.tracer,
 			Remapper:     rm,
 			ImagesFinder: app.imagFinder,
 			ForceDigests: app.RenameForceToDigest,
+			KeepTags:     app.RenameKeepTag,
 		}
 
 		err = app.inputFn(os.Stdout, os.Stdin, s)
 		if err != nil {
+			_ = app.writeExplain()
 			app.log.Error(fmt.Errorf("failed processing input, %w", err).Error())
 			os.Exit(1)
 		}
@@ -619,38 +953,56 @@ func main() {
 		// we run this through the remapper so that we'll still copy images
 		// if requested
 		for k := range app.static.Mappings {
+			// ref was already parsed during loading of mappings
+			ref, _ := reimage.ParseReference(k)
+			h := reimage.NewHistory(ref)
 			if app.ignore != nil && app.ignore.MatchString(k) {
+				if app.tracer != nil {
+					app.tracer.Ignored(h, app.ignore)
+				}
 				continue
 			}
-			// ref was already parsed during loading of mappings
-			ref, _ := name.ParseReference(k)
-			h := reimage.NewHistory(ref)
 			err = rm.ReMap(h)
 			if errors.Is(err, reimage.ErrSkip) {
 				continue
 			}
 			if err != nil {
+				_ = app.writeExplain()
 				app.log.Error(fmt.Errorf("failed processing input, %w", err).Error())
 				os.Exit(1)
 			}
 		}
 	}
 
+	err = app.writeExplain()
+	if err != nil {
+		app.log.Error(fmt.Errorf("failed writing explain output, %w", err).Error())
+		os.Exit(1)
+	}
+
+	if recorder == nil {
+		return
+	}
+
 	mappings, err = recorder.Mappings()
 	if err != nil {
 		app.log.Error(fmt.Errorf("mappings were invalid, %w", err).Error())
 		os.Exit(1)
 	}
 
-	ctx := context.Background()
-
 	err = app.checkVulns(ctx, mappings)
 	if err != nil {
 		app.log.Error(fmt.Errorf("vulncheck failed, %w", err).Error())
 		os.Exit(1)
 	}
 
-	err = app.writeMappings(mappings)
+	err = app.checkAges(ctx, mappings)
+	if err != nil {
+		app.log.Error(fmt.Errorf("image age check failed, %w", err).Error())
+		os.Exit(1)
+	}
+
+	err = app.writeMappings(ctx, mappings)
 	if err != nil {
 		app.log.Error(fmt.Errorf("failed writing mappings, %w", err).Error())
 		os.Exit(1)
@@ -0,0 +1,257 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"github.com/cerbos/reimage"
+	"github.com/google/go-containerregistry/pkg/crane"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const (
+	mappingSourceFile = "file"
+	mappingSourceImg  = "img"
+)
+
+// mappingSource is a location that a set of static mappings can be read from
+type mappingSource struct {
+	kind string
+	loc  string
+}
+
+func (ms mappingSource) String() string {
+	return ms.kind + ":" + ms.loc
+}
+
+// mappingSourcesFlag is a repeatable flag that appends to a shared list of
+// mapping sources, so that the order of file and image sources given on the
+// command line is preserved
+type mappingSourcesFlag struct {
+	sources *[]mappingSource
+	kind    string
+}
+
+func (f mappingSourcesFlag) String() string {
+	if f.sources == nil {
+		return ""
+	}
+	var res []string
+	for _, s := range *f.sources {
+		if s.kind == f.kind {
+			res = append(res, s.loc)
+		}
+	}
+	return fmt.Sprint(res)
+}
+
+func (f mappingSourcesFlag) Set(s string) error {
+	*f.sources = append(*f.sources, mappingSource{kind: f.kind, loc: s})
+	return nil
+}
+
+// readStaticMappingsImage reads the mappings stored in a registry image. If keys is
+// set, the mappings must be signed by it
+func readStaticMappingsImage(ctx context.Context, src string, keys reimage.Keyer) ([]byte, error) {
+	rimg, err := crane.Pull(src)
+	if err != nil {
+		return nil, fmt.Errorf("image pull failed, %w", err)
+	}
+
+	if keys != nil {
+		return reimage.ReadSignedMappingsArtifact(ctx, rimg, keys)
+	}
+	return reimage.ReadMappingsArtifact(rimg)
+}
+
+func readStaticMappingsFile(src string) ([]byte, error) {
+	return os.ReadFile(src)
+}
+
+// readMappingsSource reads a single set of mappings, if keys is set, mappings
+// read from images must be signed by it
+func readMappingsSource(ctx context.Context, src mappingSource, keys reimage.Keyer) (*reimage.MappingsDocument, error) {
+	var bs []byte
+	var err error
+	switch src.kind {
+	case mappingSourceFile:
+		bs, err = readStaticMappingsFile(src.loc)
+	case mappingSourceImg:
+		bs, err = readStaticMappingsImage(ctx, src.loc, keys)
+	default:
+		return nil, fmt.Errorf("unknown mappings source kind %q", src.kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading json mappings from %s, %w", src, err)
+	}
+
+	doc, err := reimage.ParseMappingsDocument(bs)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse mappings from %s, %w", src, err)
+	}
+	return doc, nil
+}
+
+// readStaticMappings reads and merges all the configured mapping sources,
+// sources given earlier on the command line take precedence
+func (a *app) readStaticMappings(ctx context.Context, confirmDigests bool) (*reimage.StaticRemapper, error) {
+	if len(a.staticSources) == 0 {
+		return nil, nil
+	}
+
+	keys, err := a.mappingsKeyer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make([]map[string]reimage.QualifiedImage, 0, len(a.staticSources))
+	for _, src := range a.staticSources {
+		doc, err := readMappingsSource(ctx, src, keys)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, doc.Mappings)
+	}
+
+	merged, conflicts, err := reimage.MergeMappings(sets...)
+	if err != nil {
+		return nil, fmt.Errorf("could not merge mappings, %w", err)
+	}
+
+	for _, c := range conflicts {
+		a.log.Warn("conflicting static mappings",
+			"image", c.Key,
+			"kept", c.Kept.Tag+"@"+c.Kept.Digest,
+			"kept_source", a.staticSources[c.KeptSource].String(),
+			"dropped", c.Dropped.Tag+"@"+c.Dropped.Digest,
+			"dropped_source", a.staticSources[c.DroppedSource].String(),
+		)
+	}
+	if len(conflicts) != 0 && a.StaticMappingsStrict {
+		return nil, fmt.Errorf("%d images have conflicting static mappings", len(conflicts))
+	}
+
+	sr, err := reimage.NewStaticRemapper(merged, confirmDigests)
+	if err != nil {
+		return nil, err
+	}
+	sr.AllowMissing = a.StaticMappingsAllowMissing
+	return sr, nil
+}
+
+// mappingsKeyer returns the key used to sign and verify mappings images, or
+// nil if no key is configured
+func (a *app) mappingsKeyer(ctx context.Context) (reimage.Keyer, error) {
+	if a.MappingsKMSKey == "" || a.mappingsKeys != nil {
+		return a.mappingsKeys, nil
+	}
+
+	kc, err := kms.NewKeyManagementClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating kms client, %w", err)
+	}
+
+	a.mappingsKeys = &reimage.KMS{
+		Client: kc,
+		Key:    a.MappingsKMSKey,
+	}
+	return a.mappingsKeys, nil
+}
+
+// mappingsMetadata describes this run of reimage, for recording alongside
+// the written mappings
+func (a *app) mappingsMetadata() reimage.MappingsMetadata {
+	md := reimage.MappingsMetadata{
+		Created:        time.Now().UTC(),
+		ReimageVersion: Version,
+		Input:          a.Input,
+	}
+	if a.MappingsOnly {
+		md.Input = "mappings"
+	}
+
+	for _, src := range a.staticSources {
+		md.Sources = append(md.Sources, src.String())
+	}
+
+	if a.vulnCheckEnabled() {
+		md.VulnPolicy = &reimage.VulnPolicy{
+			Method:       a.VulnCheckMethod,
+			MaxCVSS:      float32(a.VulnCheckMaxCVSS),
+			MaxSeverity:  a.vulnCheckMaxSeverity,
+			Unscored:     a.vulnCheckUnscored,
+			CVSSSources:  a.vulnCheckCVSS.Sources,
+			CVSSVersion:  a.vulnCheckCVSS.Version,
+			Exceptions:   a.vulnCheckExcCfgs,
+			FixableOnly:  a.VulnCheckFixableOnly,
+			IgnoredCVEs:  a.VulnCheckIgnoreList,
+			IgnoreImages: a.VulnCheckIgnoreImages,
+		}
+		if a.VulnCheckNewCVEGrace != 0 {
+			md.VulnPolicy.NewCVEGrace = a.VulnCheckNewCVEGrace.String()
+		}
+		if a.vulnCheckKEV != nil || a.vulnCheckEPSS != nil {
+			md.VulnPolicy.Exploited = a.vulnCheckExploited
+		}
+		if a.vulnCheckKEV != nil {
+			md.VulnPolicy.KEV = a.vulnCheckKEV.CatalogVersion
+		}
+		if a.vulnCheckEPSS != nil {
+			md.VulnPolicy.MinEPSS = a.VulnCheckMinEPSS
+		}
+	}
+
+	return md
+}
+
+func (a *app) writeMappings(ctx context.Context, mappings map[string]reimage.QualifiedImage) (err error) {
+	doc := reimage.NewMappingsDocument(mappings, a.mappingsMetadata())
+	bs, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("could not marshal mappings, %w", err)
+	}
+
+	if a.DryRun {
+		a.log.Info("dry-run, will not write static mappings file")
+		return nil
+	}
+
+	if a.WriteMappings != "" {
+		a.log.Info("writing mappings file", "file", a.WriteMappings)
+		err = os.WriteFile(a.WriteMappings, bs, 0600)
+		if err != nil {
+			return fmt.Errorf("could not write file, %w", err)
+		}
+	}
+
+	if a.WriteMappingsImg != "" {
+		keys, err := a.mappingsKeyer(ctx)
+		if err != nil {
+			return err
+		}
+
+		var img v1.Image
+		if keys != nil {
+			img, err = reimage.NewSignedMappingsArtifact(ctx, doc, keys)
+		} else {
+			img, err = reimage.NewMappingsArtifact(doc)
+		}
+		if err != nil {
+			return fmt.Errorf("could not create mappings artifact, %w", err)
+		}
+
+		err = crane.Push(img, a.WriteMappingsImg)
+		if err != nil {
+			return fmt.Errorf("could not push image, %w", err)
+		}
+	}
+
+	return nil
+}
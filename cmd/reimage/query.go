@@ -0,0 +1,188 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/cerbos/reimage"
+)
+
+const mappingsUsage = `usage: reimage mappings COMMAND [flags] ARGS
+
+Commands:
+  list   [flags] SOURCE...          list all the images in the mappings
+  show   [flags] SOURCE... IMAGE    show the details of an image, IMAGE may be the original
+                                    image, the final image it was mapped to, or a digest
+  search [flags] SOURCE...          list the images matching the search flags
+
+SOURCE is a mappings source, img:REF for registry images, or file:PATH (or just
+PATH) for files. Several sources are merged, earlier sources take precedence.
+`
+
+type queryCmd struct {
+	Output         string
+	Source         string
+	Target         string
+	SourceRegistry string
+	Digest         string
+	FoundCVE       string
+	IgnoredCVE     string
+	command        string
+	image          string
+	app
+}
+
+func setupQuery(args []string) (*queryCmd, error) {
+	q := &queryCmd{}
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, mappingsUsage)
+		return q, fmt.Errorf("no mappings command given")
+	}
+	q.command = args[0]
+
+	fs := flag.NewFlagSet("mappings "+q.command, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), mappingsUsage+"\n")
+		fs.PrintDefaults()
+	}
+
+	fs.BoolVar(&q.Debug, "debug", false, "enable debug logging")
+	fs.StringVar(&q.Output, "output", "text", "output format, (text or json)")
+	fs.StringVar(&q.MappingsKMSKey, "mappings-kms-key", "", "KMS key that mappings read from registry images must be signed by")
+
+	switch q.command {
+	case "list", "show":
+	case "search":
+		fs.StringVar(&q.SourceRegistry, "source-registry", "", "only images originally from this registry, (e.g. docker.io)")
+		fs.StringVar(&q.Source, "source", "", "only images whose original name matches this expression")
+		fs.StringVar(&q.Target, "target", "", "only images whose final name matches this expression")
+		fs.StringVar(&q.Digest, "digest", "", "only images with this digest")
+		fs.StringVar(&q.FoundCVE, "found-cve", "", "only images in which this CVE was found")
+		fs.StringVar(&q.IgnoredCVE, "ignored-cve", "", "only images for which this CVE was ignored")
+	case "-h", "-help", "--help":
+		fmt.Fprint(os.Stderr, mappingsUsage)
+		return q, flag.ErrHelp
+	default:
+		fmt.Fprint(os.Stderr, mappingsUsage)
+		return q, fmt.Errorf("unknown mappings command %q", q.command)
+	}
+
+	err := fs.Parse(args[1:])
+	if err != nil {
+		return q, err
+	}
+	q.setupLog()
+
+	switch q.Output {
+	case "text", "json":
+	default:
+		return q, fmt.Errorf("invalid output format, should be text or json")
+	}
+
+	srcs := fs.Args()
+	if q.command == "show" {
+		if len(srcs) < 2 {
+			fs.Usage()
+			return q, fmt.Errorf("expected mappings sources and an image")
+		}
+		q.image = srcs[len(srcs)-1]
+		srcs = srcs[:len(srcs)-1]
+	}
+	if len(srcs) == 0 {
+		fs.Usage()
+		return q, fmt.Errorf("no mappings sources given")
+	}
+
+	for _, src := range srcs {
+		q.staticSources = append(q.staticSources, parseMappingSource(src))
+	}
+
+	return q, nil
+}
+
+func (q *queryCmd) filter() (reimage.MappingFilter, error) {
+	f := reimage.MappingFilter{
+		SourceRegistry: q.SourceRegistry,
+		Digest:         q.Digest,
+		FoundCVE:       q.FoundCVE,
+		IgnoredCVE:     q.IgnoredCVE,
+	}
+
+	var err error
+	if q.Source != "" {
+		f.Source, err = regexp.Compile(q.Source)
+		if err != nil {
+			return f, fmt.Errorf("invalid source expression, %w", err)
+		}
+	}
+	if q.Target != "" {
+		f.Target, err = regexp.Compile(q.Target)
+		if err != nil {
+			return f, fmt.Errorf("invalid target expression, %w", err)
+		}
+	}
+	return f, nil
+}
+
+func (q *queryCmd) run(ctx context.Context, w io.Writer) error {
+	sr, err := q.readStaticMappings(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	var entries []reimage.MappingEntry
+	switch q.command {
+	case "show":
+		entries, err = reimage.LookupMapping(sr.Mappings, q.image)
+		if err == nil && len(entries) == 0 {
+			err = fmt.Errorf("image %s not found in the mappings", q.image)
+		}
+	case "search":
+		var f reimage.MappingFilter
+		f, err = q.filter()
+		if err == nil {
+			entries, err = reimage.FilterMappings(sr.Mappings, f)
+		}
+	default:
+		entries, err = reimage.FilterMappings(sr.Mappings, reimage.MappingFilter{})
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case q.Output == "json":
+		return reimage.WriteMappingEntriesJSON(w, entries)
+	case q.command == "show":
+		return reimage.WriteMappingEntriesDetail(w, entries)
+	default:
+		return reimage.WriteMappingEntriesText(w, entries)
+	}
+}
+
+// mappingsMain implements the mappings commands, it returns the exit code
+func mappingsMain(args []string) int {
+	q, err := setupQuery(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		q.setupLog().Error(fmt.Errorf("invalid options, %w", err).Error())
+		return exitError
+	}
+
+	err = q.run(context.Background(), os.Stdout)
+	if err != nil {
+		q.log.Error(fmt.Errorf("mappings %s failed, %w", q.command, err).Error())
+		return exitError
+	}
+	return 0
+}
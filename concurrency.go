@@ -0,0 +1,45 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// forEach calls fn with each of 0 to n-1, with at most limit calls running at
+// once (or all of them, if limit is 0), and returns once they have all completed
+func forEach(n, limit int, fn func(i int)) {
+	if limit <= 0 {
+		limit = n
+	}
+
+	wg := &sync.WaitGroup{}
+	sem := make(chan struct{}, limit)
+
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			fn(i)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// joinErrors joins the errors from a set of concurrent operations. If any of
+// the operations were cancelled, only the cancellation error is returned.
+func joinErrors(errs []error) error {
+	for _, err := range errs {
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
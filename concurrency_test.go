@@ -0,0 +1,54 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForEach(t *testing.T) {
+	var tests = []struct {
+		n, limit, expMax int
+	}{
+		{n: 10, limit: 3, expMax: 3},
+		{n: 10, limit: 1, expMax: 1},
+		{n: 2, limit: 5, expMax: 2},
+		{n: 5, limit: 0, expMax: 5},
+		{n: 0, limit: 3, expMax: 0},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var mu sync.Mutex
+			running, maxRunning := 0, 0
+			called := make([]bool, tt.n)
+
+			forEach(tt.n, tt.limit, func(i int) {
+				mu.Lock()
+				called[i] = true
+				running++
+				maxRunning = max(maxRunning, running)
+				mu.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mu.Lock()
+				running--
+				mu.Unlock()
+			})
+
+			for i, ok := range called {
+				if !ok {
+					t.Fatalf("fn not called for %d", i)
+				}
+			}
+			if maxRunning > tt.expMax {
+				t.Fatalf("too many concurrent calls, got %d, max %d", maxRunning, tt.expMax)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// MappingChangeKind describes how the mapping of an image changed between
+// two sets of mappings
+type MappingChangeKind string
+
+const (
+	MappingAdded         MappingChangeKind = "added"          // The image is only in the new mappings
+	MappingRemoved       MappingChangeKind = "removed"        // The image is only in the old mappings
+	MappingRetargeted    MappingChangeKind = "retargeted"     // The image is mapped to a different tag
+	MappingDigestChanged MappingChangeKind = "digest-changed" // The image is mapped to a different digest
+	MappingCVEsFound     MappingChangeKind = "cves-found"     // CVEs were found that were not found previously
+	MappingCVEsIgnored   MappingChangeKind = "cves-ignored"   // CVEs were ignored that were not ignored previously
+)
+
+// MappingChangeKinds lists all the kinds of change reported by DiffMappings
+var MappingChangeKinds = []MappingChangeKind{
+	MappingAdded,
+	MappingRemoved,
+	MappingRetargeted,
+	MappingDigestChanged,
+	MappingCVEsFound,
+	MappingCVEsIgnored,
+}
+
+// ParseMappingChangeKind parses the name of a kind of mapping change
+func ParseMappingChangeKind(str string) (MappingChangeKind, error) {
+	for _, k := range MappingChangeKinds {
+		if string(k) == str {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("unknown mapping change kind %q", str)
+}
+
+// MappingChange is a single difference between two sets of mappings
+type MappingChange struct {
+	Image string            `json:"image"`
+	Kind  MappingChangeKind `json:"kind"`
+	Old   *QualifiedImage   `json:"old,omitempty"`
+	New   *QualifiedImage   `json:"new,omitempty"`
+	CVEs  []string          `json:"cves,omitempty"` // The CVEs newly found, or newly ignored
+}
+
+// newCVEs returns the IDs of the CVEs in to, that are not in from
+func newCVEs(from, to []ImageVulnerability) []string {
+	seen := map[string]struct{}{}
+	for _, c := range from {
+		seen[c.ID] = struct{}{}
+	}
+
+	var res []string
+	for _, c := range to {
+		if _, ok := seen[c.ID]; !ok {
+			seen[c.ID] = struct{}{}
+			res = append(res, c.ID)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func normalizeMappings(mappings map[string]QualifiedImage) (map[string]QualifiedImage, error) {
+	res, conflicts, err := MergeMappings(mappings)
+	if err != nil {
+		return nil, err
+	}
+	if len(conflicts) != 0 {
+		return nil, fmt.Errorf("mappings are inconsistent, %s", conflicts[0])
+	}
+	return res, nil
+}
+
+// DiffMappings reports the changes needed to get from one set of mappings to
+// another. Images are compared by their normalised references. Changes are
+// sorted by image, and then in the order of MappingChangeKinds.
+func DiffMappings(from, to map[string]QualifiedImage) ([]MappingChange, error) {
+	from, err := normalizeMappings(from)
+	if err != nil {
+		return nil, fmt.Errorf("could not read old mappings, %w", err)
+	}
+	to, err = normalizeMappings(to)
+	if err != nil {
+		return nil, fmt.Errorf("could not read new mappings, %w", err)
+	}
+
+	var res []MappingChange
+	for k, o := range from {
+		if _, ok := to[k]; !ok {
+			res = append(res, MappingChange{Image: k, Kind: MappingRemoved, Old: &o})
+		}
+	}
+
+	for k, n := range to {
+		o, ok := from[k]
+		if !ok {
+			res = append(res, MappingChange{Image: k, Kind: MappingAdded, New: &n})
+			continue
+		}
+
+		oTag, nTag := o.Tag, n.Tag
+		if ref, err := NormalizeReference(o.Tag); err == nil {
+			oTag = ref
+		}
+		if ref, err := NormalizeReference(n.Tag); err == nil {
+			nTag = ref
+		}
+
+		if oTag != nTag {
+			res = append(res, MappingChange{Image: k, Kind: MappingRetargeted, Old: &o, New: &n})
+		}
+		if o.Digest != n.Digest {
+			res = append(res, MappingChange{Image: k, Kind: MappingDigestChanged, Old: &o, New: &n})
+		}
+		if cves := newCVEs(o.FoundCVEs, n.FoundCVEs); len(cves) != 0 {
+			res = append(res, MappingChange{Image: k, Kind: MappingCVEsFound, Old: &o, New: &n, CVEs: cves})
+		}
+		if cves := newCVEs(o.IgnoredCVEs, n.IgnoredCVEs); len(cves) != 0 {
+			res = append(res, MappingChange{Image: k, Kind: MappingCVEsIgnored, Old: &o, New: &n, CVEs: cves})
+		}
+	}
+
+	order := map[MappingChangeKind]int{}
+	for i, k := range MappingChangeKinds {
+		order[k] = i
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Image != res[j].Image {
+			return res[i].Image < res[j].Image
+		}
+		return order[res[i].Kind] < order[res[j].Kind]
+	})
+
+	return res, nil
+}
+
+// WriteMappingsDiffJSON writes the changes as a JSON array
+func WriteMappingsDiffJSON(w io.Writer, changes []MappingChange) error {
+	if changes == nil {
+		changes = []MappingChange{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(changes)
+}
+
+// WriteMappingsDiffText writes a human readable description of the changes
+func WriteMappingsDiffText(w io.Writer, changes []MappingChange) error {
+	for _, c := range changes {
+		var str string
+		switch c.Kind {
+		case MappingAdded:
+			str = fmt.Sprintf("+ %s => %s@%s", c.Image, c.New.Tag, c.New.Digest)
+		case MappingRemoved:
+			str = fmt.Sprintf("- %s (was %s@%s)", c.Image, c.Old.Tag, c.Old.Digest)
+		case MappingRetargeted:
+			str = fmt.Sprintf("~ %s retargeted %s => %s", c.Image, c.Old.Tag, c.New.Tag)
+		case MappingDigestChanged:
+			str = fmt.Sprintf("~ %s digest changed %s => %s", c.Image, c.Old.Digest, c.New.Digest)
+		case MappingCVEsFound:
+			str = fmt.Sprintf("! %s new CVEs found: %s", c.Image, strings.Join(c.CVEs, ", "))
+		case MappingCVEsIgnored:
+			str = fmt.Sprintf("! %s new CVEs ignored: %s", c.Image, strings.Join(c.CVEs, ", "))
+		default:
+			str = fmt.Sprintf("? %s %s", c.Image, c.Kind)
+		}
+
+		if _, err := fmt.Fprintln(w, str); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDiffMappings(t *testing.T) {
+	otherDigestStr := "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+	from := map[string]QualifiedImage{
+		"nginx:1.25":        {Tag: "mirror.example.com/nginx:1.25", Digest: testDigestStr},
+		"redis:7.2.4":       {Tag: "mirror.example.com/redis:7.2.4", Digest: testDigestStr, FoundCVEs: []ImageVulnerability{{ID: "CVE-1"}}},
+		"quay.io/old:v1":    {Tag: "mirror.example.com/old:v1", Digest: testDigestStr},
+		"quay.io/stable:v1": {Tag: "mirror.example.com/stable:v1", Digest: testDigestStr},
+	}
+	to := map[string]QualifiedImage{
+		"docker.io/library/nginx:1.25": {Tag: "other.example.com/nginx:1.25", Digest: otherDigestStr},
+		"redis:7.2.4": {
+			Tag:         "mirror.example.com/redis:7.2.4",
+			Digest:      testDigestStr,
+			FoundCVEs:   []ImageVulnerability{{ID: "CVE-3"}, {ID: "CVE-1"}, {ID: "CVE-2"}},
+			IgnoredCVEs: []ImageVulnerability{{ID: "CVE-4"}},
+		},
+		"quay.io/new:v1":    {Tag: "mirror.example.com/new:v1", Digest: testDigestStr},
+		"quay.io/stable:v1": {Tag: "mirror.example.com/stable:v1", Digest: testDigestStr},
+	}
+
+	changes, err := DiffMappings(from, to)
+	if err != nil {
+		t.Fatalf("diff failed, %v", err)
+	}
+
+	exp := []struct {
+		image string
+		kind  MappingChangeKind
+		cves  string
+	}{
+		{"docker.io/library/nginx:1.25", MappingRetargeted, ""},
+		{"docker.io/library/nginx:1.25", MappingDigestChanged, ""},
+		{"docker.io/library/redis:7.2.4", MappingCVEsFound, "CVE-2,CVE-3"},
+		{"docker.io/library/redis:7.2.4", MappingCVEsIgnored, "CVE-4"},
+		{"quay.io/new:v1", MappingAdded, ""},
+		{"quay.io/old:v1", MappingRemoved, ""},
+	}
+	if len(changes) != len(exp) {
+		t.Fatalf("incorrect changes, got %+v", changes)
+	}
+	for i, e := range exp {
+		c := changes[i]
+		if c.Image != e.image || c.Kind != e.kind || strings.Join(c.CVEs, ",") != e.cves {
+			t.Fatalf("incorrect change %d\n  got: %s %s %v\n  exp: %s %s %s", i, c.Image, c.Kind, c.CVEs, e.image, e.kind, e.cves)
+		}
+	}
+
+	txt := &bytes.Buffer{}
+	if err := WriteMappingsDiffText(txt, changes); err != nil {
+		t.Fatalf("text diff failed, %v", err)
+	}
+	if !strings.Contains(txt.String(), "- quay.io/old:v1 (was mirror.example.com/old:v1@") {
+		t.Fatalf("text diff did not show removed image:\n%s", txt)
+	}
+
+	js := &bytes.Buffer{}
+	if err := WriteMappingsDiffJSON(js, changes); err != nil {
+		t.Fatalf("json diff failed, %v", err)
+	}
+	var res []MappingChange
+	if err := json.Unmarshal(js.Bytes(), &res); err != nil {
+		t.Fatalf("json diff was invalid, %v", err)
+	}
+	if len(res) != len(changes) || res[4].New == nil || res[4].New.Tag != "mirror.example.com/new:v1" {
+		t.Fatalf("incorrect json diff output:\n%s", js)
+	}
+}
+
+func TestDiffMappings_NoChanges(t *testing.T) {
+	m := map[string]QualifiedImage{
+		"nginx:1.25": {Tag: "mirror.example.com/nginx:1.25", Digest: testDigestStr},
+	}
+	changes, err := DiffMappings(m, map[string]QualifiedImage{
+		"docker.io/library/nginx:1.25": {Tag: "mirror.example.com/nginx:1.25", Digest: testDigestStr},
+	})
+	if err != nil {
+		t.Fatalf("diff failed, %v", err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes, got %+v", changes)
+	}
+}
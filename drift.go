@@ -0,0 +1,114 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// MappingDrift records an image whose original reference now resolves to
+// a different digest than the one pinned in a set of mappings
+type MappingDrift struct {
+	Image   string `json:"image"`   // The normalised original reference
+	Pinned  string `json:"pinned"`  // The digest recorded in the mappings
+	Current string `json:"current"` // The digest the original reference currently resolves to
+}
+
+// CheckMappingsDrift resolves the original reference of every image in the
+// mappings, and reports those that have moved to a new digest, sorted by
+// image. Images that were referenced by digest cannot move, and are not
+// checked. At most concurrency lookups are run at once, (or all of them, if
+// concurrency is 0).
+func CheckMappingsDrift(ctx context.Context, mappings map[string]QualifiedImage, concurrency int) ([]MappingDrift, error) {
+	mappings, err := normalizeMappings(mappings)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for k := range mappings {
+		ref, err := ParseReference(k)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse mapping key %s, %w", k, err)
+		}
+		if _, ok := ref.(name.Tag); !ok {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	digs := make([]string, len(keys))
+	errs := make([]error, len(keys))
+	forEach(len(keys), concurrency, func(i int) {
+		digs[i], errs[i] = crane.Digest(keys[i], crane.WithContext(ctx))
+		if errs[i] != nil {
+			errs[i] = fmt.Errorf("failed reading digest for %s, %w", keys[i], errs[i])
+		}
+	})
+
+	var res []MappingDrift
+	for i, k := range keys {
+		if errs[i] == nil && digs[i] != mappings[k].Digest {
+			res = append(res, MappingDrift{Image: k, Pinned: mappings[k].Digest, Current: digs[i]})
+		}
+	}
+
+	return res, joinErrors(errs)
+}
+
+// UpdateDriftedMappings returns a copy of the mappings with the digests of the
+// drifted images replaced by their current digests. Vulnerability check results
+// for those images are cleared, as they no longer apply. The target images are
+// not updated, so drifted images must be copied again before the mappings can
+// be used.
+func UpdateDriftedMappings(mappings map[string]QualifiedImage, drifts []MappingDrift) (map[string]QualifiedImage, error) {
+	res, err := normalizeMappings(mappings)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range drifts {
+		img, ok := res[d.Image]
+		if !ok {
+			return nil, fmt.Errorf("drifted image %s is not in the mappings", d.Image)
+		}
+		img.Digest = d.Current
+		img.FoundCVEs = nil
+		img.IgnoredCVEs = nil
+		img.RecordedAt = nil
+		img.CheckedAt = nil
+		res[d.Image] = img
+	}
+
+	return res, nil
+}
+
+// WriteMappingsDriftJSON writes the drifted images as a JSON array
+func WriteMappingsDriftJSON(w io.Writer, drifts []MappingDrift) error {
+	if drifts == nil {
+		drifts = []MappingDrift{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(drifts)
+}
+
+// WriteMappingsDriftText writes a human readable list of the drifted images
+func WriteMappingsDriftText(w io.Writer, drifts []MappingDrift) error {
+	for _, d := range drifts {
+		if _, err := fmt.Fprintf(w, "%s moved %s => %s\n", d.Image, d.Pinned, d.Current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
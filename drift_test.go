@@ -0,0 +1,53 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestCheckMappingsDrift(t *testing.T) {
+	host := newTestRegistry(t)
+
+	stable := fmt.Sprintf("%s/test/stable:1.0", host)
+	moving := fmt.Sprintf("%s/test/moving:1.0", host)
+	stableDig := pushRandomImage(t, stable).DigestStr()
+	oldDig := pushRandomImage(t, moving).DigestStr()
+	newDig := pushRandomImage(t, moving).DigestStr()
+
+	mappings := map[string]QualifiedImage{
+		stable: {Tag: "mirror.example.com/stable:1.0", Digest: stableDig},
+		moving: {Tag: "mirror.example.com/moving:1.0", Digest: oldDig, FoundCVEs: []ImageVulnerability{{ID: "CVE-1"}}},
+		// digest references can't drift, and are not looked up
+		fmt.Sprintf("%s/test/gone@%s", host, testDigestStr): {Tag: "mirror.example.com/gone:1.0", Digest: testDigestStr},
+	}
+
+	drifts, err := CheckMappingsDrift(context.Background(), mappings, 2)
+	if err != nil {
+		t.Fatalf("drift check failed, %v", err)
+	}
+	if len(drifts) != 1 || drifts[0].Image != moving || drifts[0].Pinned != oldDig || drifts[0].Current != newDig {
+		t.Fatalf("incorrect drift, got %+v", drifts)
+	}
+
+	updated, err := UpdateDriftedMappings(mappings, drifts)
+	if err != nil {
+		t.Fatalf("update failed, %v", err)
+	}
+	if img := updated[moving]; img.Digest != newDig || img.FoundCVEs != nil || img.Tag != "mirror.example.com/moving:1.0" {
+		t.Fatalf("incorrect updated mapping, got %+v", img)
+	}
+	if img := updated[stable]; img.Digest != stableDig {
+		t.Fatalf("stable mapping was changed, got %+v", img)
+	}
+
+	_, err = CheckMappingsDrift(context.Background(), map[string]QualifiedImage{
+		fmt.Sprintf("%s/test/missing:1.0", host): {Tag: "mirror.example.com/missing:1.0", Digest: testDigestStr},
+	}, 1)
+	if err == nil {
+		t.Fatalf("expected error for missing upstream image")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// CVEExceptionConfig describes a single exception to the vulnerability policy.
+// Expires may be a date (e.g. 2024-12-31), in which case the exception applies
+// until the end of that day (UTC), or an RFC3339 time.
+type CVEExceptionConfig struct {
+	ID            string `json:"id" yaml:"id"`                               // the vulnerability to ignore
+	Images        string `json:"images,omitempty" yaml:"images,omitempty"`   // regexp of the images the exception applies to, all images if empty
+	Expires       string `json:"expires,omitempty" yaml:"expires,omitempty"` // when the exception stops applying, never if empty
+	Justification string `json:"justification" yaml:"justification"`         // why the vulnerability can be ignored
+}
+
+// CVEException is a compiled CVEExceptionConfig
+type CVEException struct {
+	Expires       time.Time // zero if the exception does not expire
+	images        *regexp.Regexp
+	ID            string
+	Justification string
+}
+
+// Applies returns true if the exception is for the given vulnerability and image,
+// regardless of whether it has expired
+func (e CVEException) Applies(cve ImageVulnerability, img string) bool {
+	return cve.Is(e.ID) && (e.images == nil || e.images.MatchString(img))
+}
+
+// Expired returns true if the exception has expired at the given time
+func (e CVEException) Expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// String describes the exception, for logging
+func (e CVEException) String() string {
+	str := e.ID
+	if e.images != nil {
+		str += fmt.Sprintf(" for images matching %q", e.images)
+	}
+	if !e.Expires.IsZero() {
+		str += fmt.Sprintf(" (expires %s)", e.Expires.Format(time.RFC3339))
+	}
+	return str
+}
+
+// CompileCVEExceptions compiles a set of CVE exception configs. Every exception
+// must have a justification.
+func CompileCVEExceptions(cfgs []CVEExceptionConfig) ([]CVEException, error) {
+	var res []CVEException
+	for i, cfg := range cfgs {
+		if cfg.ID == "" {
+			return nil, fmt.Errorf("cve exception %d, id must be set", i)
+		}
+		if cfg.Justification == "" {
+			return nil, fmt.Errorf("cve exception %d (%s), justification must be set", i, cfg.ID)
+		}
+
+		exc := CVEException{ID: cfg.ID, Justification: cfg.Justification}
+
+		if cfg.Images != "" {
+			re, err := regexp.Compile(cfg.Images)
+			if err != nil {
+				return nil, fmt.Errorf("cve exception %d (%s), failed to compile images regexp, %w", i, cfg.ID, err)
+			}
+			exc.images = re
+		}
+
+		if cfg.Expires != "" {
+			t, err := time.Parse(time.DateOnly, cfg.Expires)
+			if err == nil {
+				// dates are inclusive
+				t = t.AddDate(0, 0, 1)
+			} else {
+				t, err = time.Parse(time.RFC3339, cfg.Expires)
+				if err != nil {
+					return nil, fmt.Errorf("cve exception %d (%s), expiry should be a date or RFC3339 time, %w", i, cfg.ID, err)
+				}
+			}
+			exc.Expires = t
+		}
+
+		res = append(res, exc)
+	}
+	return res, nil
+}
+
+// ExpiredCVEExceptions returns the exceptions that have expired at the given time
+func ExpiredCVEExceptions(excs []CVEException, now time.Time) []CVEException {
+	var res []CVEException
+	for _, e := range excs {
+		if e.Expired(now) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
@@ -0,0 +1,112 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCompileCVEExceptions(t *testing.T) {
+	var tests = []struct {
+		cfg     CVEExceptionConfig
+		expires string
+		err     bool
+	}{
+		{cfg: CVEExceptionConfig{ID: "CVE-1", Justification: "not reachable"}},
+		{cfg: CVEExceptionConfig{ID: "CVE-1", Justification: "not reachable", Expires: "2024-06-30"}, expires: "2024-07-01T00:00:00Z"},
+		{cfg: CVEExceptionConfig{ID: "CVE-1", Justification: "not reachable", Expires: "2024-06-30T12:00:00+01:00"}, expires: "2024-06-30T12:00:00+01:00"},
+		{cfg: CVEExceptionConfig{ID: "CVE-1", Justification: "not reachable", Expires: "next week"}, err: true},
+		{cfg: CVEExceptionConfig{ID: "CVE-1", Justification: "not reachable", Images: "nginx("}, err: true},
+		{cfg: CVEExceptionConfig{ID: "CVE-1"}, err: true},
+		{cfg: CVEExceptionConfig{Justification: "not reachable"}, err: true},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			excs, err := CompileCVEExceptions([]CVEExceptionConfig{tt.cfg})
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error, %v", err)
+			}
+
+			var got string
+			if !excs[0].Expires.IsZero() {
+				got = excs[0].Expires.Format(time.RFC3339)
+			}
+			if got != tt.expires {
+				t.Fatalf("incorrect expiry, got %q, exp %q", got, tt.expires)
+			}
+		})
+	}
+}
+
+func TestVulnChecker_Exceptions(t *testing.T) {
+	excs, err := CompileCVEExceptions([]CVEExceptionConfig{
+		{ID: "CVE-1", Images: "mirror.example.com/nginx", Justification: "nginx does not use the affected module"},
+		{ID: "CVE-2", Expires: "2024-01-31", Justification: "awaiting upstream fix"},
+		{ID: "CVE-3", Expires: "2024-01-31", Justification: "old exception"},
+		{ID: "CVE-3", Expires: "2024-03-31", Justification: "extended exception"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cves := []ImageVulnerability{
+		{ID: "CVE-1", CVSS: 9.0},
+		{ID: "CVE-2", CVSS: 9.0},
+		{ID: "CVE-3", CVSS: 9.0, Justification: "stale"},
+		{ID: "CVE-4", CVSS: 2.0},
+	}
+
+	vc := &VulnChecker{
+		MaxCVSS:    7.0,
+		Exceptions: excs,
+		now:        func() time.Time { return time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC) },
+	}
+
+	res, err := vc.Evaluate("mirror.example.com/nginx@"+testDigestStr, cves)
+	if err != nil {
+		t.Fatalf("unexpected failure, %v", err)
+	}
+	exp := []ImageVulnerability{
+		{ID: "CVE-1", CVSS: 9.0, Justification: "nginx does not use the affected module"},
+		{ID: "CVE-2", CVSS: 9.0, Justification: "awaiting upstream fix"},
+		{ID: "CVE-3", CVSS: 9.0, Justification: "old exception"},
+	}
+	if !reflect.DeepEqual(res.Ignored, exp) {
+		t.Fatalf("incorrect ignored CVEs\n  got: %+v\n  exp: %+v", res.Ignored, exp)
+	}
+	if len(res.Found) != 1 || res.Found[0].ID != "CVE-4" {
+		t.Fatalf("incorrect found CVEs, got %+v", res.Found)
+	}
+
+	// after the end of January, CVE-2 is no longer ignored, and the first
+	// CVE-3 exception has expired, but is replaced by the second
+	vc.now = func() time.Time { return time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC) }
+	_, err = vc.Evaluate("mirror.example.com/nginx@"+testDigestStr, cves)
+	ice := &ImageCheckError{}
+	if !errors.As(err, &ice) || len(ice.CVEs) != 1 || ice.CVEs["CVE-2"] != 9.0 {
+		t.Fatalf("expected CVE-2 to fail, got %v", err)
+	}
+	if len(ice.Expired) != 2 || ice.Expired[0].ID != "CVE-2" || ice.Expired[1].Justification != "old exception" {
+		t.Fatalf("incorrect expired exceptions, got %+v", ice.Expired)
+	}
+
+	// CVE-1 is only ignored for nginx
+	vc.now = nil
+	vc.Exceptions = excs[:1]
+	_, err = vc.Evaluate("mirror.example.com/redis@"+testDigestStr, cves[:1])
+	if !errors.As(err, &ice) || ice.CVEs["CVE-1"] != 9.0 {
+		t.Fatalf("expected CVE-1 to fail for redis, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Trace is the complete record of the remapping of a single image
+type Trace struct {
+	History *History
+	Err     error
+}
+
+// TracingRemapper wraps a Remapper, and keeps every History passed to it,
+// including those that were skipped, or failed, so that the remapping
+// decisions can be explained
+type TracingRemapper struct {
+	Remapper
+	traces []Trace
+}
+
+// ReMap calls the wrapped Remapper, recording the history and the result
+func (t *TracingRemapper) ReMap(h *History) error {
+	err := t.Remapper.ReMap(h)
+	t.traces = append(t.traces, Trace{History: h, Err: err})
+	return err
+}
+
+// Ignored records the history of an image that was ignored before it was passed
+// to the remapper, so that it is still explained
+func (t *TracingRemapper) Ignored(h *History, ignore *regexp.Regexp) {
+	h.Record("ignore", fmt.Sprintf("ignored by regexp %s", ignore), nil)
+	t.traces = append(t.traces, Trace{History: h, Err: ErrSkip})
+}
+
+// Traces returns all the traces recorded so far
+func (t *TracingRemapper) Traces() []Trace {
+	return t.traces
+}
+
+type explainJSON struct {
+	Original string      `json:"original"`
+	Final    string      `json:"final"`
+	Digest   string      `json:"digest,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Source   ImageSource `json:"source"`
+	Steps    []Step      `json:"steps"`
+	Skipped  bool        `json:"skipped,omitempty"`
+}
+
+func (tr Trace) explainJSON() explainJSON {
+	h := tr.History
+	res := explainJSON{
+		Original: h.Original().String(),
+		Final:    h.Latest().String(),
+		Digest:   h.DigestStr,
+		Source:   h.Source,
+		Steps:    h.Steps,
+	}
+	switch {
+	case errors.Is(tr.Err, ErrSkip):
+		res.Skipped = true
+	case tr.Err != nil:
+		res.Error = tr.Err.Error()
+	}
+	if res.Steps == nil {
+		res.Steps = []Step{}
+	}
+	return res
+}
+
+// WriteExplainJSON writes the traces as a JSON array
+func WriteExplainJSON(w io.Writer, trs []Trace) error {
+	res := make([]explainJSON, 0, len(trs))
+	for _, tr := range trs {
+		res = append(res, tr.explainJSON())
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+// WriteExplainText writes a human readable description of the
+// remapping decisions in each of the traces
+func WriteExplainText(w io.Writer, trs []Trace) error {
+	for _, tr := range trs {
+		ej := tr.explainJSON()
+
+		var src []string
+		for _, s := range []string{ej.Source.Kind, ej.Source.Namespace, ej.Source.Name, ej.Source.Container} {
+			if s != "" {
+				src = append(src, s)
+			}
+		}
+		srcStr := ""
+		if len(src) != 0 {
+			srcStr = fmt.Sprintf(" (%s)", strings.Join(src, "/"))
+		}
+
+		result := ej.Final
+		switch {
+		case ej.Skipped:
+			result = "skipped"
+		case ej.Error != "":
+			result = "failed: " + ej.Error
+		}
+
+		if _, err := fmt.Fprintf(w, "%s%s => %s\n", ej.Original, srcStr, result); err != nil {
+			return err
+		}
+		for _, s := range ej.Steps {
+			out := s.Output
+			if s.Input == s.Output {
+				out = "unchanged"
+			}
+			if _, err := fmt.Fprintf(w, "  %-8s %s: %s\n", s.Remapper, out, s.Reason); err != nil {
+				return err
+			}
+		}
+		if ej.Digest != "" {
+			if _, err := fmt.Fprintf(w, "  digest   %s\n", ej.Digest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
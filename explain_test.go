@@ -0,0 +1,133 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTracingRemapper(t *testing.T) {
+	rules, err := CompileRewriteRules([]RewriteRuleConfig{
+		{Prefix: "quay.io/", Replace: "mirror.example.com/quay/"},
+	})
+	if err != nil {
+		t.Fatalf("test borked, %v", err)
+	}
+
+	sr, err := NewStaticRemapper(map[string]QualifiedImage{
+		"nginx:1.25": {Tag: "mirror.example.com/nginx:1.25", Digest: testDigestStr},
+	}, false)
+	if err != nil {
+		t.Fatalf("test borked, %v", err)
+	}
+	sr.AllowMissing = true
+
+	tr := &TracingRemapper{
+		Remapper: MultiRemapper{
+			&IgnoreRemapper{Ignore: regexp.MustCompile("^example.com/")},
+			sr,
+			&RewriteRemapper{Rules: rules},
+		},
+	}
+
+	for _, img := range []string{"nginx:1.25", "example.com/app:1", "quay.io/prometheus/node-exporter:v1.8.0"} {
+		ref, _ := ParseReference(img)
+		_ = tr.ReMap(NewHistory(ref))
+	}
+
+	trs := tr.Traces()
+	if len(trs) != 3 {
+		t.Fatalf("expected 3 traces, got %d", len(trs))
+	}
+
+	expSteps := [][]Step{
+		{
+			{Remapper: "static", Input: "nginx:1.25", Output: "mirror.example.com/nginx:1.25", Reason: "static mapping hit"},
+			{Remapper: "rewrite", Input: "mirror.example.com/nginx:1.25", Output: "mirror.example.com/nginx:1.25", Reason: "no rewrite rule matched"},
+		},
+		{
+			{Remapper: "ignore", Input: "example.com/app:1", Output: "example.com/app:1", Reason: "ignored by regexp ^example.com/"},
+		},
+		{
+			{Remapper: "static", Input: "quay.io/prometheus/node-exporter:v1.8.0", Output: "quay.io/prometheus/node-exporter:v1.8.0", Reason: "not in static mappings, passed through"},
+			{Remapper: "rewrite", Input: "quay.io/prometheus/node-exporter:v1.8.0", Output: "mirror.example.com/quay/prometheus/node-exporter:v1.8.0", Reason: "matched rewrite rule quay.io/"},
+		},
+	}
+	for i, tr := range trs {
+		if len(tr.History.Steps) != len(expSteps[i]) {
+			t.Fatalf("trace %d, incorrect steps:\n  got: %v\n  exp: %v\n", i, tr.History.Steps, expSteps[i])
+		}
+		for j := range expSteps[i] {
+			if tr.History.Steps[j] != expSteps[i][j] {
+				t.Fatalf("trace %d, incorrect step %d:\n  got: %v\n  exp: %v\n", i, j, tr.History.Steps[j], expSteps[i][j])
+			}
+		}
+	}
+
+	txt := &bytes.Buffer{}
+	if err := WriteExplainText(txt, trs); err != nil {
+		t.Fatalf("text explain failed, %v", err)
+	}
+	if !strings.Contains(txt.String(), "example.com/app:1 => skipped") {
+		t.Fatalf("text explain did not show skipped image:\n%s", txt)
+	}
+
+	js := &bytes.Buffer{}
+	if err := WriteExplainJSON(js, trs); err != nil {
+		t.Fatalf("json explain failed, %v", err)
+	}
+	var res []explainJSON
+	if err := json.Unmarshal(js.Bytes(), &res); err != nil {
+		t.Fatalf("json explain was invalid, %v", err)
+	}
+	if !res[1].Skipped || res[0].Final != "mirror.example.com/nginx:1.25" || res[0].Digest != testDigestStr {
+		t.Fatalf("incorrect json explain output:\n%s", js)
+	}
+}
+
+func TestRenameUpdater_TracesIgnored(t *testing.T) {
+	in := `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+  namespace: web
+spec:
+  containers:
+  - name: app
+    image: example.com/app:1
+`
+	tr := &TracingRemapper{Remapper: MultiRemapper{}}
+	ru := &RenameUpdater{
+		Ignore:   regexp.MustCompile("^example.com/"),
+		Tracer:   tr,
+		Remapper: tr,
+	}
+
+	out := &bytes.Buffer{}
+	if err := ProcessK8s(out, bytes.NewBufferString(in), ru); err != nil {
+		t.Fatalf("process failed, %v", err)
+	}
+
+	trs := tr.Traces()
+	if len(trs) != 1 {
+		t.Fatalf("expected the ignored image to be traced, got %d traces", len(trs))
+	}
+	exp := Step{Remapper: "ignore", Input: "example.com/app:1", Output: "example.com/app:1", Reason: "ignored by regexp ^example.com/"}
+	if len(trs[0].History.Steps) != 1 || trs[0].History.Steps[0] != exp || trs[0].History.Source.Container != "app" {
+		t.Fatalf("incorrect trace of ignored image, got %+v", trs[0].History)
+	}
+
+	txt := &bytes.Buffer{}
+	if err := WriteExplainText(txt, trs); err != nil {
+		t.Fatalf("text explain failed, %v", err)
+	}
+	if !strings.Contains(txt.String(), "example.com/app:1 (Pod/web/app/app) => skipped") {
+		t.Fatalf("text explain did not show ignored image:\n%s", txt)
+	}
+}
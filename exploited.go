@@ -0,0 +1,193 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ExploitedPolicy controls how the VulnChecker treats vulnerabilities that are
+// known, or likely, to be exploited
+type ExploitedPolicy string
+
+const (
+	ExploitedFail ExploitedPolicy = "fail" // Exploited vulnerabilities fail the check, regardless of their score (the default)
+	ExploitedWarn ExploitedPolicy = "warn" // Exploited vulnerabilities are only recorded, and only fail the check if they violate the policy
+)
+
+// ParseExploitedPolicy parses the name of an exploited vulnerability policy
+func ParseExploitedPolicy(str string) (ExploitedPolicy, error) {
+	switch p := ExploitedPolicy(str); p {
+	case ExploitedFail, ExploitedWarn:
+		return p, nil
+	case "":
+		return ExploitedFail, nil
+	default:
+		return "", fmt.Errorf("unknown exploited vulnerability policy %q, should be fail or warn", str)
+	}
+}
+
+// KEVEntry is a vulnerability listed in a known exploited vulnerabilities catalog
+type KEVEntry struct {
+	CVEID                      string `json:"cveID"`
+	VendorProject              string `json:"vendorProject"`
+	Product                    string `json:"product"`
+	VulnerabilityName          string `json:"vulnerabilityName"`
+	DateAdded                  string `json:"dateAdded"`
+	DueDate                    string `json:"dueDate"`
+	KnownRansomwareCampaignUse string `json:"knownRansomwareCampaignUse"`
+}
+
+// KEVCatalog is a catalog of known exploited vulnerabilities, in the JSON format of
+// the CISA KEV catalog
+type KEVCatalog struct {
+	byCVE           map[string]*KEVEntry
+	Title           string     `json:"title"`
+	CatalogVersion  string     `json:"catalogVersion"`
+	Vulnerabilities []KEVEntry `json:"vulnerabilities"`
+}
+
+// ParseKEVCatalog parses a known exploited vulnerabilities catalog
+func ParseKEVCatalog(bs []byte) (*KEVCatalog, error) {
+	cat := KEVCatalog{}
+	if err := json.Unmarshal(bs, &cat); err != nil {
+		return nil, fmt.Errorf("could not parse kev catalog, %w", err)
+	}
+	if cat.Vulnerabilities == nil {
+		return nil, errors.New("could not parse kev catalog, no vulnerabilities listed")
+	}
+
+	cat.byCVE = make(map[string]*KEVEntry, len(cat.Vulnerabilities))
+	for i, e := range cat.Vulnerabilities {
+		cat.byCVE[strings.ToUpper(e.CVEID)] = &cat.Vulnerabilities[i]
+	}
+
+	return &cat, nil
+}
+
+// Lookup returns the catalog's entry for the vulnerability, or nil if it is not listed
+func (c *KEVCatalog) Lookup(id string) *KEVEntry {
+	return c.byCVE[strings.ToUpper(id)]
+}
+
+// EPSSScore is the Exploit Prediction Scoring System score of a vulnerability
+type EPSSScore struct {
+	Probability float64 // The probability of exploitation in the next 30 days
+	Percentile  float64 // The proportion of vulnerabilities with the same, or a lower, probability
+}
+
+// EPSSScores are the EPSS scores of a set of vulnerabilities
+type EPSSScores struct {
+	byCVE        map[string]EPSSScore
+	ModelVersion string
+	ScoreDate    string
+}
+
+// ParseEPSSScores parses EPSS scores in the CSV format published by FIRST, (with
+// cve, epss and percentile columns, and an optional leading comment line giving
+// the model version and score date). Gzipped files are decompressed.
+func ParseEPSSScores(r io.Reader) (*EPSSScores, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress epss scores, %w", err)
+		}
+		defer gr.Close()
+		br = bufio.NewReader(gr)
+	}
+
+	res := EPSSScores{byCVE: map[string]EPSSScore{}}
+
+	// e.g. #model_version:v2023.03.01,score_date:2024-01-01T00:00:00+0000
+	if c, _ := br.Peek(1); len(c) == 1 && c[0] == '#' {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("could not read epss scores, %w", err)
+		}
+		for _, field := range strings.Split(strings.TrimSpace(line[1:]), ",") {
+			k, v, _ := strings.Cut(field, ":")
+			switch k {
+			case "model_version":
+				res.ModelVersion = v
+			case "score_date":
+				res.ScoreDate = v
+			}
+		}
+	}
+
+	cr := csv.NewReader(br)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read epss scores, %w", err)
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.TrimSpace(h)] = i
+	}
+	for _, col := range []string{"cve", "epss", "percentile"} {
+		if _, ok := cols[col]; !ok {
+			return nil, fmt.Errorf("could not read epss scores, missing %s column", col)
+		}
+	}
+
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read epss scores, %w", err)
+		}
+
+		prob, err := strconv.ParseFloat(rec[cols["epss"]], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid epss score for %s, %w", rec[cols["cve"]], err)
+		}
+		pct, err := strconv.ParseFloat(rec[cols["percentile"]], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid epss percentile for %s, %w", rec[cols["cve"]], err)
+		}
+		res.byCVE[strings.ToUpper(rec[cols["cve"]])] = EPSSScore{Probability: prob, Percentile: pct}
+	}
+
+	return &res, nil
+}
+
+// Lookup returns the score of the vulnerability, if it has one
+func (e *EPSSScores) Lookup(id string) (EPSSScore, bool) {
+	s, ok := e.byCVE[strings.ToUpper(id)]
+	return s, ok
+}
+
+// exploited returns a description of the sources that list the vulnerability as
+// known, or likely, to be exploited, or "" if none do
+func (vc *VulnChecker) exploited(cve ImageVulnerability) string {
+	var srcs []string
+	for _, id := range append([]string{cve.ID}, cve.Aliases...) {
+		if vc.KEV != nil {
+			if e := vc.KEV.Lookup(id); e != nil {
+				srcs = append(srcs, fmt.Sprintf("%s kev %s (added %s)", id, vc.KEV.CatalogVersion, e.DateAdded))
+			}
+		}
+		if vc.EPSS != nil && vc.MinEPSS != 0 {
+			if s, ok := vc.EPSS.Lookup(id); ok && s.Probability >= vc.MinEPSS {
+				srcs = append(srcs, fmt.Sprintf("%s epss %.4f (%s)", id, s.Probability, vc.EPSS.ScoreDate))
+			}
+		}
+		if srcs != nil {
+			break
+		}
+	}
+	return strings.Join(srcs, ", ")
+}
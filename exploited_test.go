@@ -0,0 +1,123 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var testKEVCatalog = `{
+  "title": "CISA Catalog of Known Exploited Vulnerabilities",
+  "catalogVersion": "2024.06.01",
+  "dateReleased": "2024-06-01T12:00:00.000Z",
+  "count": 2,
+  "vulnerabilities": [
+    {"cveID": "CVE-2021-44228", "vendorProject": "Apache", "product": "Log4j2", "vulnerabilityName": "Apache Log4j2 Remote Code Execution Vulnerability", "dateAdded": "2021-12-10", "dueDate": "2021-12-24", "knownRansomwareCampaignUse": "Known"},
+    {"cveID": "CVE-2023-44487", "vendorProject": "IETF", "product": "HTTP/2", "vulnerabilityName": "HTTP/2 Rapid Reset Attack Vulnerability", "dateAdded": "2023-10-10", "dueDate": "2023-10-31", "knownRansomwareCampaignUse": "Unknown"}
+  ]
+}`
+
+var testEPSSScores = `#model_version:v2023.03.01,score_date:2024-06-01T00:00:00+0000
+cve,epss,percentile
+CVE-2021-44228,0.97565,0.99996
+CVE-2024-0001,0.61234,0.98000
+CVE-2024-0002,0.00043,0.09000
+`
+
+func TestParseEPSSScores(t *testing.T) {
+	gz := &bytes.Buffer{}
+	gw := gzip.NewWriter(gz)
+	_, _ = gw.Write([]byte(testEPSSScores))
+	gw.Close()
+
+	for i, in := range [][]byte{[]byte(testEPSSScores), gz.Bytes()} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			scores, err := ParseEPSSScores(bytes.NewReader(in))
+			if err != nil {
+				t.Fatalf("could not parse scores, %v", err)
+			}
+			if scores.ModelVersion != "v2023.03.01" || scores.ScoreDate != "2024-06-01T00:00:00+0000" {
+				t.Fatalf("incorrect model version or date, got %+v", scores)
+			}
+			if s, ok := scores.Lookup("cve-2024-0001"); !ok || s.Probability != 0.61234 || s.Percentile != 0.98 {
+				t.Fatalf("incorrect score, got %+v", s)
+			}
+			if _, ok := scores.Lookup("CVE-2024-0003"); ok {
+				t.Fatalf("expected no score for unlisted cve")
+			}
+		})
+	}
+
+	if _, err := ParseEPSSScores(strings.NewReader("cve,score\nCVE-1,0.1\n")); err == nil {
+		t.Fatalf("expected error for missing columns")
+	}
+}
+
+func TestVulnChecker_Exploited(t *testing.T) {
+	kev, err := ParseKEVCatalog([]byte(testKEVCatalog))
+	if err != nil {
+		t.Fatalf("could not parse kev catalog, %v", err)
+	}
+	epss, err := ParseEPSSScores(strings.NewReader(testEPSSScores))
+	if err != nil {
+		t.Fatalf("could not parse epss scores, %v", err)
+	}
+
+	cves := []ImageVulnerability{
+		{ID: "CVE-2021-44228", CVSS: 10.0},
+		{ID: "GHSA-m425-mq94-257g", Aliases: []string{"CVE-2023-44487"}, CVSS: 5.3},
+		{ID: "CVE-2024-0001", CVSS: 4.0},
+		{ID: "CVE-2024-0002", CVSS: 3.0},
+	}
+
+	var tests = []struct {
+		vc  *VulnChecker
+		exp string // the CVEs that fail the policy
+	}{
+		{&VulnChecker{KEV: kev}, "CVE-2021-44228,GHSA-m425-mq94-257g"},
+		{&VulnChecker{EPSS: epss, MinEPSS: 0.5}, "CVE-2021-44228,CVE-2024-0001"},
+		{&VulnChecker{KEV: kev, EPSS: epss, MinEPSS: 0.5, MaxCVSS: 3.5}, "CVE-2021-44228,CVE-2024-0001,GHSA-m425-mq94-257g"},
+		{&VulnChecker{KEV: kev, MaxCVSS: 7.0, Exploited: ExploitedWarn}, "CVE-2021-44228"},
+		{&VulnChecker{KEV: kev, CVEIgnoreList: []string{"CVE-2023-44487"}}, "CVE-2021-44228"},
+		// exploited vulnerabilities are not deferred until a fix is available
+		{&VulnChecker{KEV: kev, MaxCVSS: 7.0, FixableOnly: true}, "CVE-2021-44228,GHSA-m425-mq94-257g"},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			res, err := tt.vc.Evaluate("nginx:1.25", cves)
+			ice := &ImageCheckError{}
+			if !errors.As(err, &ice) {
+				t.Fatalf("expected check to fail, got %v", err)
+			}
+			var got []string
+			for id := range ice.CVEs {
+				got = append(got, id)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != tt.exp {
+				t.Fatalf("incorrect failing CVEs\n  got: %v\n  exp: %s", got, tt.exp)
+			}
+			if tt.vc.Exploited != ExploitedWarn && !strings.Contains(ice.Error(), "exploited: CVE-2021-44228") {
+				t.Fatalf("expected exploited sources in error, got %v", ice)
+			}
+
+			// the matched sources are recorded, whether or not the vulnerability failed
+			for _, cve := range append(append(res.Failed, res.Found...), res.Ignored...) {
+				if tt.vc.KEV != nil && cve.ID == "CVE-2021-44228" && !strings.Contains(cve.Exploited, "kev 2024.06.01 (added 2021-12-10)") {
+					t.Fatalf("expected kev source to be recorded, got %q", cve.Exploited)
+				}
+				if cve.ID == "CVE-2024-0002" && cve.Exploited != "" {
+					t.Fatalf("unexpected exploited source for %s, got %q", cve.ID, cve.Exploited)
+				}
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ require (
 	cloud.google.com/go/grafeas v0.3.13
 	cloud.google.com/go/kms v1.20.4
 	github.com/AsaiYusuke/jsonpath v1.6.0
+	github.com/Masterminds/semver/v3 v3.2.1
 	github.com/buildkite/shellwords v0.0.0-20180315110454-59467a9b8e10
 	github.com/google/go-containerregistry v0.20.2
 	github.com/googleapis/gax-go/v2 v2.14.1
@@ -54,7 +55,6 @@ require (
 	github.com/AzureAD/microsoft-authentication-library-for-go v1.2.2 // indirect
 	github.com/BurntSushi/toml v1.4.0 // indirect
 	github.com/Masterminds/goutils v1.1.1 // indirect
-	github.com/Masterminds/semver/v3 v3.2.1 // indirect
 	github.com/Masterminds/sprig/v3 v3.2.3 // indirect
 	github.com/Microsoft/go-winio v0.6.2 // indirect
 	github.com/ProtonMail/go-crypto v1.1.3 // indirect
@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"log/slog"
 	"math"
+	"sort"
 	"time"
 
 	grafeas "cloud.google.com/go/grafeas/apiv1"
@@ -33,7 +34,14 @@ type GrafeasClient interface {
 type GrafeasVulnGetter struct {
 	Grafeas GrafeasClient
 	Logger
-	Parent     string
+	Parent string
+
+	// CVSS selects the scores and severities to report. The scores recorded by
+	// Grafeas come from the vulnerability's note, so only the version is used
+	// to select a score. Sources may be nvd, for the severity given by the
+	// note, or vendor, for the effective severity given by the distribution.
+	CVSS CVSSPreference
+
 	RetryMax   int
 	RetryDelay time.Duration
 }
@@ -106,17 +114,57 @@ func (vc *GrafeasVulnGetter) check(ctx context.Context, dig name.Digest) ([]Imag
 	var res []ImageVulnerability
 
 	for _, vocc := range voccs {
-		score := vocc.GetCvssScore()
 		cve := vocc.GetShortDescription()
-		res = append(res, ImageVulnerability{
-			ID:   cve,
-			CVSS: score,
-		})
+		iv := ImageVulnerability{
+			ID:       cve,
+			CVSS:     grafeasScore(vocc, vc.CVSS.Version),
+			Severity: grafeasSeverity(vocc, vc.CVSS.Sources),
+
+			FixAvailable: vocc.GetFixAvailable(),
+		}
+		if pis := vocc.GetPackageIssue(); len(pis) > 0 {
+			iv.Package = pis[0].GetAffectedPackage()
+			iv.Version = pis[0].GetAffectedVersion().GetFullName()
+			iv.FixedVersion = pis[0].GetFixedVersion().GetFullName()
+		}
+		res = append(res, iv)
 	}
 
 	return res, nil
 }
 
+// grafeasScore returns the score of the requested CVSS version
+func grafeasScore(vocc *grafeaspb.VulnerabilityOccurrence, version CVSSVersion) float32 {
+	if version == CVSSAnyVersion && vocc.GetCvssScore() != 0 {
+		return vocc.GetCvssScore()
+	}
+	return version.pick(vocc.GetCvssv3().GetBaseScore(), vocc.GetCvssV2().GetBaseScore())
+}
+
+// grafeasSeverity returns the severity from the first of the sources that
+// has one. By default this is the effective severity of the vulnerability, if
+// set, or the severity given by the vulnerability's note
+func grafeasSeverity(vocc *grafeaspb.VulnerabilityOccurrence, sources []string) string {
+	if len(sources) == 0 {
+		sources = []string{CVSSSourceVendor, "nvd"}
+	}
+
+	for _, src := range sources {
+		sev := grafeaspb.Severity_SEVERITY_UNSPECIFIED
+		switch src {
+		case CVSSSourceVendor:
+			sev = vocc.GetEffectiveSeverity()
+		case "nvd":
+			sev = vocc.GetSeverity()
+		}
+		if sev != grafeaspb.Severity_SEVERITY_UNSPECIFIED {
+			return sev.String()
+		}
+	}
+
+	return ""
+}
+
 // GetVulnerabilities waits for a completed vulnerability discovery, and then check that an image
 // has no CVEs that violate the configured policy
 func (vc *GrafeasVulnGetter) GetVulnerabilities(ctx context.Context, dig name.Digest) ([]ImageVulnerability, error) {
@@ -193,8 +241,9 @@ type GrafeasAttester struct {
 	Grafeas GrafeasClient
 	Keys    Keyer
 	Logger
-	Parent  string
-	NoteRef string
+	Parent      string
+	NoteRef     string
+	Concurrency int // Maximum number of images attested at once by AttestImages, unlimited if 0
 }
 
 // Get retrieves all the Attestation occurrences for the given image that use the provided
@@ -308,3 +357,36 @@ func (t *GrafeasAttester) Attest(ctx context.Context, dig name.Digest) error {
 
 	return err
 }
+
+// AttestImages creates NoteRef attestations for all the images, running at most
+// Concurrency attestations at once. Images that share a digest are only attested once.
+func (t *GrafeasAttester) AttestImages(ctx context.Context, imgs map[string]QualifiedImage) error {
+	var errs []error
+
+	// dedupe the digests we will sign
+	digs := map[string]name.Digest{}
+	for _, img := range imgs {
+		dig, err := img.DigestReference()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		digs[dig.String()] = dig
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	strs := make([]string, 0, len(digs))
+	for str := range digs {
+		strs = append(strs, str)
+	}
+	sort.Strings(strs)
+
+	errs = make([]error, len(strs))
+	forEach(len(strs), t.Concurrency, func(i int) {
+		errs[i] = t.Attest(ctx, digs[strs[i]])
+	})
+
+	return joinErrors(errs)
+}
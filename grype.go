@@ -0,0 +1,175 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+type grypeCVSS struct {
+	Source  string
+	Version string
+	Metrics struct {
+		BaseScore float32
+	}
+}
+
+type grypeVulnerability struct {
+	ID        string
+	Namespace string
+	Severity  string
+	Fix       struct {
+		State    string
+		Versions []string
+	}
+	CVSS []grypeCVSS
+}
+
+type grypeMatch struct {
+	Artifact struct {
+		Name    string
+		Version string
+	}
+	Vulnerability          grypeVulnerability
+	RelatedVulnerabilities []grypeVulnerability
+}
+
+type grypeReport struct {
+	Matches []grypeMatch
+}
+
+// vendor returns true if the vulnerability record comes from the vendor of
+// the package, rather than the NVD
+func (v grypeVulnerability) vendor() bool {
+	return !strings.HasPrefix(v.Namespace, "nvd")
+}
+
+// fromSource returns true if the record is from the given source
+func (v grypeVulnerability) fromSource(src string) bool {
+	if src == CVSSSourceVendor {
+		return v.vendor()
+	}
+	return strings.HasPrefix(v.Namespace, src)
+}
+
+// grypeScore returns the highest score of the preferred version in the records
+func grypeScore(vs []grypeVulnerability, pref CVSSPreference) float32 {
+	var v3, v2 float32
+	for _, v := range vs {
+		for _, cv := range v.CVSS {
+			s := cv.Metrics.BaseScore
+			switch {
+			case strings.HasPrefix(cv.Version, "3") && s > v3:
+				v3 = s
+			case strings.HasPrefix(cv.Version, "2") && s > v2:
+				v2 = s
+			}
+		}
+	}
+	return pref.Version.pick(v3, v2)
+}
+
+// records returns the match's vulnerability, and its related vulnerabilities
+func (m grypeMatch) records() []grypeVulnerability {
+	return append([]grypeVulnerability{m.Vulnerability}, m.RelatedVulnerabilities...)
+}
+
+// score returns the highest score from the first preferred source that has
+// one, or the highest score from any source. The record of the vulnerability
+// from the package's vendor is used for the vendor source, other sources are
+// matched against the record's namespace (e.g. nvd).
+func (m grypeMatch) score(pref CVSSPreference) float32 {
+	for _, src := range pref.Sources {
+		var vs []grypeVulnerability
+		for _, v := range m.records() {
+			if v.fromSource(src) {
+				vs = append(vs, v)
+			}
+		}
+		if s := grypeScore(vs, pref); s != 0 {
+			return s
+		}
+	}
+	return grypeScore(m.records(), pref)
+}
+
+// severity returns the severity from the first preferred source that has one,
+// or the severity of the vulnerability record, falling back to that of the related
+// records
+func (m grypeMatch) severity(pref CVSSPreference) string {
+	srcs := append(append([]string{}, pref.Sources...), CVSSSourceVendor, "")
+	for _, src := range srcs {
+		for _, v := range m.records() {
+			if !v.fromSource(src) {
+				continue
+			}
+			// grype uses mixed case, (e.g. High), unknown severities are dropped
+			if sev, err := ParseSeverity(v.Severity); err == nil && sev != SeverityUnknown {
+				return sev.String()
+			}
+		}
+	}
+	return ""
+}
+
+// aliases returns the IDs of the related vulnerabilities
+func (m grypeMatch) aliases() []string {
+	var res []string
+	for _, v := range m.RelatedVulnerabilities {
+		if !strings.EqualFold(v.ID, m.Vulnerability.ID) && !slices.Contains(res, v.ID) {
+			res = append(res, v.ID)
+		}
+	}
+	return res
+}
+
+func parseGrypeReport(bs []byte, pref CVSSPreference) ([]ImageVulnerability, error) {
+	gr := grypeReport{}
+	if err := json.Unmarshal(bs, &gr); err != nil {
+		return nil, fmt.Errorf("could not parse grype report, %w", err)
+	}
+
+	var res []ImageVulnerability
+	for _, m := range gr.Matches {
+		res = append(res, ImageVulnerability{
+			ID:           m.Vulnerability.ID,
+			Aliases:      m.aliases(),
+			CVSS:         m.score(pref),
+			Severity:     m.severity(pref),
+			Package:      m.Artifact.Name,
+			Version:      m.Artifact.Version,
+			FixedVersion: strings.Join(m.Vulnerability.Fix.Versions, ", "),
+			FixAvailable: m.Vulnerability.Fix.State == "fixed",
+		})
+	}
+
+	return res, nil
+}
+
+// GrypeVulnGetter retrieves vulnerabilities by running grype, or any command
+// compatible with grype's JSON output
+type GrypeVulnGetter struct {
+	Command []string
+	CVSS    CVSSPreference // Selects which of the scores and severities reported by grype are used
+}
+
+func (vc *GrypeVulnGetter) GetVulnerabilities(ctx context.Context, dig name.Digest) ([]ImageVulnerability, error) {
+	args := append(append([]string{}, vc.Command[1:]...), dig.String())
+
+	//nolint:gosec
+	cmd := exec.CommandContext(ctx, vc.Command[0], args...)
+	bs, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseGrypeReport(bs, vc.CVSS)
+}
@@ -0,0 +1,87 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestParseGrypeReport(t *testing.T) {
+	bs, err := os.ReadFile("testdata/grype.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		pref CVSSPreference
+		exp  []ImageVulnerability
+	}{
+		{
+			pref: CVSSPreference{},
+			exp: []ImageVulnerability{
+				{ID: "CVE-2023-5678", CVSS: 5.3, Severity: "MEDIUM", Package: "libssl3", Version: "3.0.11-1~deb12u2", FixedVersion: "3.0.13-1~deb12u1", FixAvailable: true},
+				{ID: "GHSA-m425-mq94-257g", Aliases: []string{"CVE-2023-44487"}, CVSS: 7.5, Severity: "HIGH", Package: "google.golang.org/grpc", Version: "1.56.2", FixedVersion: "1.56.3, 1.57.1", FixAvailable: true},
+				{ID: "CVE-2011-3374", CVSS: 3.7, Severity: "LOW", Package: "apt", Version: "2.6.1"},
+			},
+		},
+		{
+			pref: CVSSPreference{Sources: []string{"nvd"}, Version: CVSSV2},
+			exp: []ImageVulnerability{
+				{ID: "CVE-2023-5678", Severity: "MEDIUM", Package: "libssl3", Version: "3.0.11-1~deb12u2", FixedVersion: "3.0.13-1~deb12u1", FixAvailable: true},
+				{ID: "GHSA-m425-mq94-257g", Aliases: []string{"CVE-2023-44487"}, CVSS: 5.0, Severity: "HIGH", Package: "google.golang.org/grpc", Version: "1.56.2", FixedVersion: "1.56.3, 1.57.1", FixAvailable: true},
+				{ID: "CVE-2011-3374", CVSS: 4.3, Severity: "LOW", Package: "apt", Version: "2.6.1"},
+			},
+		},
+		{
+			pref: CVSSPreference{Sources: []string{CVSSSourceVendor}},
+			exp: []ImageVulnerability{
+				{ID: "CVE-2023-5678", CVSS: 5.3, Severity: "MEDIUM", Package: "libssl3", Version: "3.0.11-1~deb12u2", FixedVersion: "3.0.13-1~deb12u1", FixAvailable: true},
+				{ID: "GHSA-m425-mq94-257g", Aliases: []string{"CVE-2023-44487"}, CVSS: 7.5, Severity: "HIGH", Package: "google.golang.org/grpc", Version: "1.56.2", FixedVersion: "1.56.3, 1.57.1", FixAvailable: true},
+				// the vendor's negligible severity is reported as low
+				{ID: "CVE-2011-3374", CVSS: 3.7, Severity: "LOW", Package: "apt", Version: "2.6.1"},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			res, err := parseGrypeReport(bs, tt.pref)
+			if err != nil {
+				t.Fatalf("could not parse report, %v", err)
+			}
+			if !reflect.DeepEqual(res, tt.exp) {
+				t.Fatalf("incorrect vulnerabilities\n  got: %+v\n  exp: %+v", res, tt.exp)
+			}
+		})
+	}
+}
+
+func TestGrypeVulnGetter(t *testing.T) {
+	dig, err := name.NewDigest("example.com/nginx@" + testDigestStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the image is passed as $0, and ignored
+	vg := &GrypeVulnGetter{Command: []string{"sh", "-c", "cat testdata/grype.json"}}
+	vc := &VulnChecker{Getter: vg, MaxCVSS: 7.0, CVEIgnoreList: []string{"CVE-2023-44487"}}
+	res, err := vc.Check(context.Background(), dig)
+	if err != nil {
+		t.Fatalf("check failed, %v", err)
+	}
+	if len(res.Found) != 2 || len(res.Ignored) != 1 || res.Ignored[0].ID != "GHSA-m425-mq94-257g" {
+		t.Fatalf("incorrect result, got %+v", res)
+	}
+
+	vg.Command = []string{"sh", "-c", "echo not json"}
+	if _, err := vc.Check(context.Background(), dig); err == nil {
+		t.Fatalf("expected error for invalid report")
+	}
+}
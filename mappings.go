@@ -0,0 +1,170 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MappingsSchemaVersion is the version of the mappings document format
+// written by this version of reimage
+const MappingsSchemaVersion = 2
+
+// VulnPolicy records the vulnerability checking policy applied to
+// the images in a set of mappings
+type VulnPolicy struct {
+	Method       string               `json:"method"`                      // The method used to find vulnerabilities, e.g. trivy or grafeas
+	Unscored     UnscoredPolicy       `json:"unscored,omitempty"`          // How vulnerabilities with no score were treated
+	CVSSVersion  CVSSVersion          `json:"cvssVersion,omitempty"`       // The CVSS version of the scores used
+	NewCVEGrace  string               `json:"newCVEGracePeriod,omitempty"` // Vulnerabilities published more recently than this did not fail the check
+	IgnoreImages string               `json:"ignoreImages,omitempty"`      // Expression matching images that were not checked
+	Exploited    ExploitedPolicy      `json:"exploited,omitempty"`         // How exploited vulnerabilities were treated
+	KEV          string               `json:"kev,omitempty"`               // The version of the known exploited vulnerabilities catalog used
+	CVSSSources  []string             `json:"cvssSources,omitempty"`       // Preferred sources of scores and severities
+	IgnoredCVEs  []string             `json:"ignoredCVEs,omitempty"`       // CVEs that were explicitly ignored
+	Exceptions   []CVEExceptionConfig `json:"exceptions,omitempty"`        // Scoped CVE exceptions
+	MaxSeverity  Severity             `json:"maxSeverity,omitempty"`       // The maximum severity allowed
+	MinEPSS      float64              `json:"minEPSS,omitempty"`           // The EPSS probability at which vulnerabilities were treated as exploited
+	MaxCVSS      float32              `json:"maxCVSS"`                     // The maximum CVSS score allowed
+	FixableOnly  bool                 `json:"fixableOnly,omitempty"`       // Only vulnerabilities with a fix available failed the check
+}
+
+// MappingsMetadata describes the run of reimage that produced a set of mappings
+type MappingsMetadata struct {
+	Created        time.Time   `json:"created"`
+	ReimageVersion string      `json:"reimageVersion,omitempty"`
+	Input          string      `json:"input,omitempty"`      // The type of input processed, e.g. k8s, yaml or mappings
+	VulnPolicy     *VulnPolicy `json:"vulnPolicy,omitempty"` // Only set if vulnerability checks were run
+	Sources        []string    `json:"sources,omitempty"`    // Any static mappings sources the mappings were built from
+}
+
+// MappingsDocument is the versioned form in which mappings are stored
+type MappingsDocument struct {
+	Mappings      map[string]QualifiedImage `json:"mappings"`
+	Metadata      MappingsMetadata          `json:"metadata"`
+	SchemaVersion int                       `json:"schemaVersion"`
+}
+
+// NewMappingsDocument creates a document for the mappings, in the current schema version
+func NewMappingsDocument(mappings map[string]QualifiedImage, md MappingsMetadata) *MappingsDocument {
+	return &MappingsDocument{
+		SchemaVersion: MappingsSchemaVersion,
+		Metadata:      md,
+		Mappings:      mappings,
+	}
+}
+
+// ParseMappingsDocument parses a stored set of mappings. Both versioned documents
+// and the original, unversioned, bare map of images are accepted. Bare maps
+// are returned with a SchemaVersion of 0, and empty metadata.
+func ParseMappingsDocument(bs []byte) (*MappingsDocument, error) {
+	var probe map[string]json.RawMessage
+	err := json.Unmarshal(bs, &probe)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse mappings as a JSON object, %w", err)
+	}
+
+	// schemaVersion is not a valid image reference, so cannot be the key
+	// of a bare mapping
+	if _, ok := probe["schemaVersion"]; !ok {
+		mappings := map[string]QualifiedImage{}
+		err = json.Unmarshal(bs, &mappings)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse unversioned mappings, %w", err)
+		}
+		return &MappingsDocument{Mappings: mappings}, nil
+	}
+
+	doc := &MappingsDocument{}
+	err = json.Unmarshal(bs, doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse mappings document, %w", err)
+	}
+	if doc.SchemaVersion < 1 || doc.SchemaVersion > MappingsSchemaVersion {
+		return nil, fmt.Errorf("unsupported mappings schema version %d, (max supported is %d)", doc.SchemaVersion, MappingsSchemaVersion)
+	}
+	if doc.Mappings == nil {
+		doc.Mappings = map[string]QualifiedImage{}
+	}
+	return doc, nil
+}
+
+// MappingConflict describes an image that was mapped differently by
+// more than one set of mappings
+type MappingConflict struct {
+	Key           string         // The normalised key of the image
+	Kept          QualifiedImage // The mapping that was kept
+	Dropped       QualifiedImage // The mapping that was discarded
+	KeptSource    int            // The index of the set the kept mapping came from
+	DroppedSource int            // The index of the set the discarded mapping came from
+}
+
+func (mc MappingConflict) String() string {
+	return fmt.Sprintf(
+		"%s is mapped to %s@%s by source %d, and to %s@%s by source %d",
+		mc.Key,
+		mc.Kept.Tag, mc.Kept.Digest, mc.KeptSource,
+		mc.Dropped.Tag, mc.Dropped.Digest, mc.DroppedSource,
+	)
+}
+
+func sameTarget(a, b QualifiedImage) bool {
+	if a.Digest != b.Digest {
+		return false
+	}
+	if a.Tag == b.Tag {
+		return true
+	}
+	aKey, aErr := NormalizeReference(a.Tag)
+	bKey, bErr := NormalizeReference(b.Tag)
+	return aErr == nil && bErr == nil && aKey == bKey
+}
+
+// MergeMappings merges several sets of mappings into one, keyed by the
+// normalised form of the original image reference. Sets earlier in the list
+// take precedence, any image that is mapped differently by more than one set
+// is returned as a conflict, sorted by key.
+func MergeMappings(sets ...map[string]QualifiedImage) (map[string]QualifiedImage, []MappingConflict, error) {
+	res := map[string]QualifiedImage{}
+	srcs := map[string]int{}
+	var conflicts []MappingConflict
+
+	for i, set := range sets {
+		for k, v := range set {
+			key, err := NormalizeReference(k)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not parse mapping key %s in source %d, %w", k, i, err)
+			}
+
+			existing, ok := res[key]
+			if !ok {
+				res[key] = v
+				srcs[key] = i
+				continue
+			}
+
+			if !sameTarget(existing, v) {
+				conflicts = append(conflicts, MappingConflict{
+					Key:           key,
+					Kept:          existing,
+					Dropped:       v,
+					KeptSource:    srcs[key],
+					DroppedSource: i,
+				})
+			}
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Key != conflicts[j].Key {
+			return conflicts[i].Key < conflicts[j].Key
+		}
+		return conflicts[i].DroppedSource < conflicts[j].DroppedSource
+	})
+
+	return res, conflicts, nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMergeMappings(t *testing.T) {
+	first := map[string]QualifiedImage{
+		"nginx:1.25":  {Tag: "mirror.example.com/nginx:1.25", Digest: testDigestStr},
+		"redis:7.2.4": {Tag: "mirror.example.com/redis:7.2.4", Digest: testDigestStr},
+	}
+	second := map[string]QualifiedImage{
+		// same mapping, in a different form, is not a conflict
+		"docker.io/library/nginx:1.25": {Tag: "mirror.example.com/nginx:1.25", Digest: testDigestStr},
+		"redis:7.2.4":                  {Tag: "other.example.com/redis:7.2.4", Digest: testDigestStr},
+		"quay.io/app:v1":               {Tag: "mirror.example.com/quay/app:v1", Digest: testDigestStr},
+	}
+
+	res, conflicts, err := MergeMappings(first, second)
+	if err != nil {
+		t.Fatalf("merge failed, %v", err)
+	}
+
+	exp := map[string]string{
+		"docker.io/library/nginx:1.25":  "mirror.example.com/nginx:1.25",
+		"docker.io/library/redis:7.2.4": "mirror.example.com/redis:7.2.4",
+		"quay.io/app:v1":                "mirror.example.com/quay/app:v1",
+	}
+	if len(res) != len(exp) {
+		t.Fatalf("incorrect merged mappings, got %v", res)
+	}
+	for k, v := range exp {
+		if res[k].Tag != v {
+			t.Fatalf("incorrect mapping for %s, got %q, exp %q", k, res[k].Tag, v)
+		}
+	}
+
+	if len(conflicts) != 1 {
+		t.Fatalf("expected 1 conflict, got %v", conflicts)
+	}
+	c := conflicts[0]
+	if c.Key != "docker.io/library/redis:7.2.4" || c.KeptSource != 0 || c.DroppedSource != 1 || c.Dropped.Tag != "other.example.com/redis:7.2.4" {
+		t.Fatalf("incorrect conflict, got %v", c)
+	}
+}
+
+func TestMergeMappings_InvalidKey(t *testing.T) {
+	_, _, err := MergeMappings(map[string]QualifiedImage{"Not An Image": {}})
+	if err == nil {
+		t.Fatalf("expected error for invalid key")
+	}
+}
+
+func TestStaticRemapper_Fallback(t *testing.T) {
+	rules, err := CompileRewriteRules([]RewriteRuleConfig{
+		{Prefix: "quay.io/", Replace: "mirror.example.com/quay/"},
+		{Prefix: "mirror.example.com/", Replace: "wrong.example.com/"},
+	})
+	if err != nil {
+		t.Fatalf("test borked, %v", err)
+	}
+
+	sr, err := NewStaticRemapper(map[string]QualifiedImage{
+		"nginx:1.25": {Tag: "mirror.example.com/nginx:1.25", Digest: testDigestStr},
+	}, false)
+	if err != nil {
+		t.Fatalf("test borked, %v", err)
+	}
+	sr.AllowMissing = true
+	sr.Fallback = &RewriteRemapper{Rules: rules}
+
+	for img, exp := range map[string]string{
+		"nginx:1.25":     "mirror.example.com/nginx:1.25",
+		"quay.io/app:v1": "mirror.example.com/quay/app:v1",
+	} {
+		ref, _ := ParseReference(img)
+		h := NewHistory(ref)
+		if err := sr.ReMap(h); err != nil {
+			t.Fatalf("remap of %s failed, %v", img, err)
+		}
+		if h.Latest().String() != exp {
+			t.Fatalf("incorrect remap of %s, got %s, exp %s", img, h.Latest(), exp)
+		}
+	}
+}
+
+func TestParseMappingsDocument(t *testing.T) {
+	legacy := `{"nginx:1.25":{"tag":"mirror.example.com/nginx:1.25","digest":"` + testDigestStr + `"}}`
+	doc, err := ParseMappingsDocument([]byte(legacy))
+	if err != nil {
+		t.Fatalf("could not parse legacy mappings, %v", err)
+	}
+	if doc.SchemaVersion != 0 || doc.Mappings["nginx:1.25"].Tag != "mirror.example.com/nginx:1.25" {
+		t.Fatalf("incorrect legacy mappings, got %#v", doc)
+	}
+
+	checked := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mappings := doc.Mappings
+	img := mappings["nginx:1.25"]
+	img.CheckedAt = &checked
+	mappings["nginx:1.25"] = img
+
+	bs, err := json.Marshal(NewMappingsDocument(mappings, MappingsMetadata{
+		Created:        checked,
+		ReimageVersion: "v1.2.3",
+		Input:          "k8s",
+		VulnPolicy:     &VulnPolicy{Method: "trivy", MaxCVSS: 7.5},
+	}))
+	if err != nil {
+		t.Fatalf("could not marshal mappings, %v", err)
+	}
+
+	doc, err = ParseMappingsDocument(bs)
+	if err != nil {
+		t.Fatalf("could not parse mappings document, %v", err)
+	}
+	if doc.SchemaVersion != MappingsSchemaVersion ||
+		doc.Metadata.ReimageVersion != "v1.2.3" ||
+		doc.Metadata.VulnPolicy == nil || doc.Metadata.VulnPolicy.MaxCVSS != 7.5 ||
+		doc.Mappings["nginx:1.25"].CheckedAt == nil || !doc.Mappings["nginx:1.25"].CheckedAt.Equal(checked) {
+		t.Fatalf("incorrect mappings document, got %s", bs)
+	}
+
+	_, err = ParseMappingsDocument([]byte(`{"schemaVersion":99,"mappings":{}}`))
+	if err == nil {
+		t.Fatalf("expected error for unsupported schema version")
+	}
+}
+
+func TestRecorderRemapper_RecordedAt(t *testing.T) {
+	recorded := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rr := &RecorderRemapper{now: func() time.Time { return recorded.In(time.FixedZone("test", 3600)) }}
+
+	ref, _ := ParseReference("nginx:1.25")
+	h := NewHistory(ref)
+	h.DigestStr = testDigestStr
+	_ = rr.ReMap(h)
+
+	mps, err := rr.Mappings()
+	if err != nil {
+		t.Fatalf("mappings failed, %v", err)
+	}
+	img := mps["docker.io/library/nginx:1.25"]
+	if img.RecordedAt == nil || !img.RecordedAt.Equal(recorded) || img.RecordedAt.Location() != time.UTC || img.CheckedAt != nil {
+		t.Fatalf("incorrect recorded time, got %+v", img)
+	}
+}
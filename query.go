@@ -0,0 +1,208 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// MappingEntry is a single image from a set of mappings
+type MappingEntry struct {
+	Image string `json:"image"` // The normalised original reference
+	QualifiedImage
+}
+
+// MappingFilter selects images from a set of mappings, images must match
+// every field that is set
+type MappingFilter struct {
+	SourceRegistry string         // The registry the image was originally from, e.g. docker.io
+	Source         *regexp.Regexp // Matched against the normalised original reference
+	Target         *regexp.Regexp // Matched against the target image
+	Digest         string         // The digest of the image
+	FoundCVE       string         // The ID of a CVE found in the image
+	IgnoredCVE     string         // The ID of a CVE that was ignored for the image
+}
+
+func hasCVE(cves []ImageVulnerability, id string) bool {
+	for _, c := range cves {
+		if c.Is(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinVulns(vulns []ImageVulnerability) string {
+	strs := make([]string, 0, len(vulns))
+	for _, v := range vulns {
+		strs = append(strs, v.String())
+	}
+	return strings.Join(strs, ", ")
+}
+
+// Match returns true if the image matches the filter. key must be a
+// normalised reference
+func (f MappingFilter) Match(key string, img QualifiedImage) bool {
+	if f.SourceRegistry != "" {
+		ref, err := ParseReference(key)
+		if err != nil {
+			return false
+		}
+		// compared via name.Registry so that docker.io and index.docker.io match
+		reg, err := name.NewRegistry(f.SourceRegistry)
+		if err != nil || ref.Context().RegistryStr() != reg.RegistryStr() {
+			return false
+		}
+	}
+	if f.Source != nil && !f.Source.MatchString(key) {
+		return false
+	}
+	if f.Target != nil && !f.Target.MatchString(img.Tag) {
+		return false
+	}
+	if f.Digest != "" && img.Digest != f.Digest {
+		return false
+	}
+	if f.FoundCVE != "" && !hasCVE(img.FoundCVEs, f.FoundCVE) {
+		return false
+	}
+	if f.IgnoredCVE != "" && !hasCVE(img.IgnoredCVEs, f.IgnoredCVE) {
+		return false
+	}
+	return true
+}
+
+func sortedEntries(mappings map[string]QualifiedImage, match func(string, QualifiedImage) bool) []MappingEntry {
+	var res []MappingEntry
+	for k, v := range mappings {
+		if match(k, v) {
+			res = append(res, MappingEntry{Image: k, QualifiedImage: v})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Image < res[j].Image
+	})
+	return res
+}
+
+// FilterMappings returns the images in the mappings that match the filter,
+// sorted by image
+func FilterMappings(mappings map[string]QualifiedImage, f MappingFilter) ([]MappingEntry, error) {
+	mappings, err := normalizeMappings(mappings)
+	if err != nil {
+		return nil, err
+	}
+
+	return sortedEntries(mappings, f.Match), nil
+}
+
+// LookupMapping finds images in the mappings. The image can be given as the
+// original reference, as the final target reference (with or without its
+// digest), or as just a digest. More than one image is returned if several
+// original images were mapped to the same target.
+func LookupMapping(mappings map[string]QualifiedImage, image string) ([]MappingEntry, error) {
+	mappings, err := normalizeMappings(mappings)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(image, "sha256:") {
+		return sortedEntries(mappings, func(_ string, img QualifiedImage) bool {
+			return img.Digest == image
+		}), nil
+	}
+
+	key, err := NormalizeReference(image)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse image %s, %w", image, err)
+	}
+
+	if img, ok := mappings[key]; ok {
+		return []MappingEntry{{Image: key, QualifiedImage: img}}, nil
+	}
+
+	ref, err := ParseReference(image)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse image %s, %w", image, err)
+	}
+	target := FormatReference(ref, RefStyleFull)
+
+	return sortedEntries(mappings, func(_ string, img QualifiedImage) bool {
+		tref, err := ParseReference(img.Tag)
+		if err != nil {
+			return false
+		}
+		if FormatReference(tref, RefStyleFull) == target {
+			return true
+		}
+		// the target may have been given in digest form
+		dig := tref.Context().Digest(img.Digest)
+		return FormatReference(dig, RefStyleFull) == target
+	}), nil
+}
+
+// WriteMappingEntriesJSON writes the entries as a JSON array
+func WriteMappingEntriesJSON(w io.Writer, entries []MappingEntry) error {
+	if entries == nil {
+		entries = []MappingEntry{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// WriteMappingEntriesText writes one line per entry, giving the original image,
+// and the image it is mapped to
+func WriteMappingEntriesText(w io.Writer, entries []MappingEntry) error {
+	for _, e := range entries {
+		if _, err := fmt.Fprintf(w, "%s => %s@%s\n", e.Image, e.Tag, e.Digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteMappingEntriesDetail writes all the details of each entry
+func WriteMappingEntriesDetail(w io.Writer, entries []MappingEntry) error {
+	for i, e := range entries {
+		if i != 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+
+		lines := [][2]string{
+			{"image", e.Image},
+			{"target", e.Tag},
+			{"digest", e.Digest},
+			{"resolved tag", e.ResolvedTag},
+			{"found CVEs", joinVulns(e.FoundCVEs)},
+			{"ignored CVEs", joinVulns(e.IgnoredCVEs)},
+		}
+		if e.RecordedAt != nil {
+			lines = append(lines, [2]string{"recorded at", e.RecordedAt.String()})
+		}
+		if e.CheckedAt != nil {
+			lines = append(lines, [2]string{"checked at", e.CheckedAt.String()})
+		}
+
+		for _, l := range lines {
+			if l[1] == "" {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "%-13s %s\n", l[0]+":", l[1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var testQueryMappings = map[string]QualifiedImage{
+	"nginx:1.25": {
+		Tag:       "mirror.example.com/docker.io/library/nginx:1.25",
+		Digest:    testDigestStr,
+		FoundCVEs: []ImageVulnerability{{ID: "CVE-2024-0001", CVSS: 5}},
+	},
+	"quay.io/prometheus/node-exporter:v1.8.0": {
+		Tag:         "mirror.example.com/quay.io/prometheus/node-exporter:v1.8.0",
+		Digest:      "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+		IgnoredCVEs: []ImageVulnerability{{ID: "CVE-2024-0002", CVSS: 9.8}},
+	},
+	"redis:7.2.4": {
+		Tag:       "mirror.example.com/docker.io/library/redis:7.2.4",
+		Digest:    "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+		FoundCVEs: []ImageVulnerability{{ID: "CVE-2024-0001", CVSS: 5}, {ID: "CVE-2024-0003", CVSS: 2}},
+	},
+}
+
+func entryImages(es []MappingEntry) string {
+	var res []string
+	for _, e := range es {
+		res = append(res, e.Image)
+	}
+	return strings.Join(res, ",")
+}
+
+func TestFilterMappings(t *testing.T) {
+	var tests = []struct {
+		filter MappingFilter
+		exp    string
+	}{
+		{MappingFilter{}, "docker.io/library/nginx:1.25,docker.io/library/redis:7.2.4,quay.io/prometheus/node-exporter:v1.8.0"},
+		{MappingFilter{SourceRegistry: "docker.io"}, "docker.io/library/nginx:1.25,docker.io/library/redis:7.2.4"},
+		{MappingFilter{Source: regexp.MustCompile("prometheus")}, "quay.io/prometheus/node-exporter:v1.8.0"},
+		{MappingFilter{Target: regexp.MustCompile("redis")}, "docker.io/library/redis:7.2.4"},
+		{MappingFilter{Digest: testDigestStr}, "docker.io/library/nginx:1.25"},
+		{MappingFilter{FoundCVE: "CVE-2024-0001"}, "docker.io/library/nginx:1.25,docker.io/library/redis:7.2.4"},
+		{MappingFilter{FoundCVE: "CVE-2024-0001", SourceRegistry: "quay.io"}, ""},
+		{MappingFilter{IgnoredCVE: "CVE-2024-0002"}, "quay.io/prometheus/node-exporter:v1.8.0"},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			res, err := FilterMappings(testQueryMappings, tt.filter)
+			if err != nil {
+				t.Fatalf("filter failed, %v", err)
+			}
+			if got := entryImages(res); got != tt.exp {
+				t.Fatalf("incorrect result\n  got: %s\n  exp: %s", got, tt.exp)
+			}
+		})
+	}
+}
+
+func TestLookupMapping(t *testing.T) {
+	var tests = []struct {
+		image string
+		exp   string
+	}{
+		{"nginx:1.25", "docker.io/library/nginx:1.25"},
+		{"docker.io/library/redis:7.2.4", "docker.io/library/redis:7.2.4"},
+		{"mirror.example.com/docker.io/library/redis:7.2.4", "docker.io/library/redis:7.2.4"},
+		{"mirror.example.com/docker.io/library/nginx@" + testDigestStr, "docker.io/library/nginx:1.25"},
+		{"sha256:1111111111111111111111111111111111111111111111111111111111111111", "quay.io/prometheus/node-exporter:v1.8.0"},
+		{"alpine:3.20", ""},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			res, err := LookupMapping(testQueryMappings, tt.image)
+			if err != nil {
+				t.Fatalf("lookup failed, %v", err)
+			}
+			if got := entryImages(res); got != tt.exp {
+				t.Fatalf("incorrect result\n  got: %s\n  exp: %s", got, tt.exp)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// TaggedDigest is an image reference carrying both a tag and a digest
+// (e.g. nginx:1.25@sha256:...). The digest is authoritative, the tag is
+// only retained for readability
+type TaggedDigest struct {
+	name.Digest
+	tag string
+}
+
+// NewTaggedDigest creates a TaggedDigest for dig, with the provided tag
+func NewTaggedDigest(dig name.Digest, tag string) TaggedDigest {
+	return TaggedDigest{Digest: dig, tag: tag}
+}
+
+// TagStr returns the tag component of the reference
+func (t TaggedDigest) TagStr() string {
+	return t.tag
+}
+
+// Name returns the fully qualified reference name
+func (t TaggedDigest) Name() string {
+	return t.Context().Name() + ":" + t.tag + "@" + t.DigestStr()
+}
+
+// String returns the reference in the same form it was parsed
+func (t TaggedDigest) String() string {
+	repo := strings.TrimSuffix(t.Digest.String(), "@"+t.DigestStr())
+	return repo + ":" + t.tag + "@" + t.DigestStr()
+}
+
+// ParseReference parses s as an image reference. References with both a
+// tag and a digest are returned as a TaggedDigest (name.ParseReference would
+// discard the tag), all other references are parsed by name.ParseReference
+func ParseReference(s string, opts ...name.Option) (name.Reference, error) {
+	tagPart, digestPart, ok := strings.Cut(s, "@")
+	if !ok {
+		return name.ParseReference(s, opts...)
+	}
+
+	tag, err := name.NewTag(tagPart, opts...)
+	if err != nil {
+		return name.ParseReference(s, opts...)
+	}
+
+	repoPart := strings.TrimSuffix(tagPart, ":"+tag.TagStr())
+	if repoPart == tagPart {
+		// no explicit tag
+		return name.ParseReference(s, opts...)
+	}
+
+	dig, err := name.NewDigest(repoPart+"@"+digestPart, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse tagged digest reference %s, %w", s, err)
+	}
+
+	return NewTaggedDigest(dig, tag.TagStr()), nil
+}
+
+// refString returns a string form of ref that can be parsed by
+// name.ParseReference (and so can be passed to crane). TaggedDigest
+// references are returned in digest form
+func refString(ref name.Reference) string {
+	if td, ok := ref.(TaggedDigest); ok {
+		return td.Digest.String()
+	}
+	return ref.String()
+}
+
+// refTag returns the tag of a reference, or "" if it has none
+func refTag(ref name.Reference) string {
+	switch r := ref.(type) {
+	case name.Tag:
+		return r.TagStr()
+	case TaggedDigest:
+		return r.TagStr()
+	default:
+		return ""
+	}
+}
+
+// RefStyle selects how FormatReference writes image references
+type RefStyle int
+
+const (
+	// RefStyleFull writes fully qualified references, e.g. docker.io/library/nginx:latest
+	RefStyleFull RefStyle = iota
+	// RefStyleShort writes Docker Hub references in their short form, e.g. nginx:latest.
+	// References to other registries are fully qualified
+	RefStyleShort
+)
+
+// ParseRefStyle parses a RefStyle from its name, (full or short)
+func ParseRefStyle(s string) (RefStyle, error) {
+	switch s {
+	case "full":
+		return RefStyleFull, nil
+	case "short":
+		return RefStyleShort, nil
+	default:
+		return RefStyleFull, fmt.Errorf("unknown reference style %q, should be full or short", s)
+	}
+}
+
+// dockerHubRegistry is the canonical name of the Docker Hub registry,
+// go-containerregistry uses index.docker.io
+const dockerHubRegistry = "docker.io"
+
+// FormatReference writes ref in a normalised form, so that equivalent
+// references (e.g. nginx, nginx:latest, docker.io/library/nginx:latest and
+// index.docker.io/library/nginx:latest) are formatted identically. Tags are
+// always included, (latest if none was given)
+func FormatReference(ref name.Reference, style RefStyle) string {
+	refCtx := ref.Context()
+	reg := refCtx.RegistryStr()
+	repo := refCtx.RepositoryStr()
+
+	var prefix string
+	switch {
+	case reg != name.DefaultRegistry:
+		prefix = reg + "/" + repo
+	case style == RefStyleShort:
+		prefix = strings.TrimPrefix(repo, "library/")
+	default:
+		prefix = dockerHubRegistry + "/" + repo
+	}
+
+	switch r := ref.(type) {
+	case TaggedDigest:
+		return prefix + ":" + r.TagStr() + "@" + r.DigestStr()
+	case name.Digest:
+		return prefix + "@" + r.DigestStr()
+	default:
+		return prefix + ":" + ref.Identifier()
+	}
+}
+
+// NormalizeReference parses s, and returns its canonical, fully qualified, form
+func NormalizeReference(s string) (string, error) {
+	ref, err := ParseReference(s)
+	if err != nil {
+		return "", err
+	}
+	return FormatReference(ref, RefStyleFull), nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+const testDigestStr = "sha256:abcdabcdabceabcdabcdabcdabcdabcdabcdabcdabcaacbcbfedabcaefacbaea"
+
+func TestParseReference(t *testing.T) {
+	var tests = []struct {
+		in      string
+		expType string
+		expStr  string
+		expName string
+		expErr  bool
+	}{
+		{"nginx:1.25", "name.Tag", "nginx:1.25", "index.docker.io/library/nginx:1.25", false},
+		{"nginx@" + testDigestStr, "name.Digest", "nginx@" + testDigestStr, "index.docker.io/library/nginx@" + testDigestStr, false},
+		{"nginx:1.25@" + testDigestStr, "reimage.TaggedDigest", "nginx:1.25@" + testDigestStr, "index.docker.io/library/nginx:1.25@" + testDigestStr, false},
+		{"example.com:5000/nginx:1.25@" + testDigestStr, "reimage.TaggedDigest", "example.com:5000/nginx:1.25@" + testDigestStr, "example.com:5000/nginx:1.25@" + testDigestStr, false},
+		{"example.com:5000/nginx@sha256:abc", "", "", "", true},
+		{"nginx:1.25@sha256:abc", "", "", "", true},
+	}
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ref, err := ParseReference(tt.in)
+			if tt.expErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", ref)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse failed, %v", err)
+			}
+
+			if typ := fmt.Sprintf("%T", ref); typ != tt.expType {
+				t.Fatalf("incorrect type, got %s, exp %s", typ, tt.expType)
+			}
+			if ref.String() != tt.expStr {
+				t.Fatalf("incorrect string:\n  got: %s\n  exp: %s\n", ref.String(), tt.expStr)
+			}
+			if ref.Name() != tt.expName {
+				t.Fatalf("incorrect name:\n  got: %s\n  exp: %s\n", ref.Name(), tt.expName)
+			}
+			if _, err := ParseReference(refString(ref)); err != nil {
+				t.Fatalf("refString was not parseable, %v", err)
+			}
+		})
+	}
+}
+
+func TestRenameUpdater_KeepTags(t *testing.T) {
+	sr, err := NewStaticRemapper(map[string]QualifiedImage{
+		"nginx:1.25":                       {Tag: "example.com/mirror/nginx:1.25", Digest: testDigestStr},
+		"redis:7@" + testDigestStr:         {Tag: "example.com/mirror/redis:7@" + testDigestStr, Digest: testDigestStr},
+		"example.com/app@" + testDigestStr: {Tag: "example.com/app@" + testDigestStr, Digest: testDigestStr},
+	}, false)
+	if err != nil {
+		t.Fatalf("test borked, %v", err)
+	}
+
+	var tests = []struct {
+		in           string
+		forceDigests bool
+		keepTags     bool
+		exp          string
+	}{
+		{"nginx:1.25", false, false, "example.com/mirror/nginx:1.25"},
+		{"nginx:1.25", true, false, "example.com/mirror/nginx@" + testDigestStr},
+		{"nginx:1.25", true, true, "example.com/mirror/nginx:1.25@" + testDigestStr},
+		{"redis:7@" + testDigestStr, false, false, "example.com/mirror/redis:7@" + testDigestStr},
+		{"redis:7@" + testDigestStr, true, true, "example.com/mirror/redis:7@" + testDigestStr},
+		{"redis:7@" + testDigestStr, true, false, "example.com/mirror/redis@" + testDigestStr},
+		{"example.com/app@" + testDigestStr, true, true, "example.com/app@" + testDigestStr},
+	}
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ru := &RenameUpdater{
+				Remapper:     sr,
+				ForceDigests: tt.forceDigests,
+				KeepTags:     tt.keepTags,
+			}
+
+			res, err := ru.remapImageString(tt.in, ImageSource{})
+			if err != nil {
+				t.Fatalf("remap failed, %v", err)
+			}
+			if res != tt.exp {
+				t.Fatalf("incorrect image:\n  got: %s\n  exp: %s\n", res, tt.exp)
+			}
+		})
+	}
+}
+
+func TestFormatReference(t *testing.T) {
+	var tests = []struct {
+		in       string
+		expFull  string
+		expShort string
+	}{
+		{"nginx", "docker.io/library/nginx:latest", "nginx:latest"},
+		{"nginx:latest", "docker.io/library/nginx:latest", "nginx:latest"},
+		{"docker.io/library/nginx:latest", "docker.io/library/nginx:latest", "nginx:latest"},
+		{"index.docker.io/library/nginx:latest", "docker.io/library/nginx:latest", "nginx:latest"},
+		{"bitnami/redis:7", "docker.io/bitnami/redis:7", "bitnami/redis:7"},
+		{"quay.io/prometheus/node-exporter", "quay.io/prometheus/node-exporter:latest", "quay.io/prometheus/node-exporter:latest"},
+		{"nginx@" + testDigestStr, "docker.io/library/nginx@" + testDigestStr, "nginx@" + testDigestStr},
+		{"nginx:1.25@" + testDigestStr, "docker.io/library/nginx:1.25@" + testDigestStr, "nginx:1.25@" + testDigestStr},
+	}
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ref, err := ParseReference(tt.in)
+			if err != nil {
+				t.Fatalf("test borked, %v", err)
+			}
+			if res := FormatReference(ref, RefStyleFull); res != tt.expFull {
+				t.Fatalf("incorrect full form:\n  got: %s\n  exp: %s\n", res, tt.expFull)
+			}
+			if res := FormatReference(ref, RefStyleShort); res != tt.expShort {
+				t.Fatalf("incorrect short form:\n  got: %s\n  exp: %s\n", res, tt.expShort)
+			}
+		})
+	}
+}
+
+func TestStaticRemapper_NormalisedKeys(t *testing.T) {
+	target := QualifiedImage{Tag: "example.com/mirror/nginx:latest", Digest: testDigestStr}
+	sr, err := NewStaticRemapper(map[string]QualifiedImage{
+		"nginx":                          target,
+		"docker.io/library/nginx:latest": target,
+	}, false)
+	if err != nil {
+		t.Fatalf("equivalent keys with the same mapping should be allowed, %v", err)
+	}
+
+	for _, img := range []string{"nginx", "nginx:latest", "docker.io/library/nginx", "index.docker.io/library/nginx:latest"} {
+		ref, _ := ParseReference(img)
+		h := NewHistory(ref)
+		if err := sr.ReMap(h); err != nil {
+			t.Fatalf("lookup of %s failed, %v", img, err)
+		}
+		if h.Latest().String() != target.Tag {
+			t.Fatalf("incorrect mapping for %s, got %s", img, h.Latest())
+		}
+	}
+
+	_, err = NewStaticRemapper(map[string]QualifiedImage{
+		"nginx":                          target,
+		"docker.io/library/nginx:latest": {Tag: "example.com/other/nginx:latest", Digest: testDigestStr},
+	}, false)
+	if err == nil {
+		t.Fatalf("expected conflicting equivalent keys to fail")
+	}
+}
+
+func TestRecorderRemapper_KeyStyle(t *testing.T) {
+	for style, exp := range map[RefStyle]string{
+		RefStyleFull:  "docker.io/library/nginx:latest",
+		RefStyleShort: "nginx:latest",
+	} {
+		rr := &RecorderRemapper{KeyStyle: style}
+		for _, img := range []string{"nginx", "index.docker.io/library/nginx:latest"} {
+			ref, _ := ParseReference(img)
+			h := NewHistory(ref)
+			h.DigestStr = testDigestStr
+			_ = rr.ReMap(h)
+		}
+
+		mps, err := rr.Mappings()
+		if err != nil {
+			t.Fatalf("mappings failed, %v", err)
+		}
+		if _, ok := mps[exp]; !ok || len(mps) != 1 {
+			t.Fatalf("expected a single key %s, got %v", exp, mps)
+		}
+	}
+}
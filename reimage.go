@@ -7,17 +7,18 @@ package reimage
 import (
 	"bufio"
 	"bytes"
-	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"regexp"
-	"sort"
 	"strings"
-	"sync"
 	"text/template"
+	"text/template/parse"
+	"time"
 
 	"github.com/AsaiYusuke/jsonpath"
 	"github.com/google/go-containerregistry/pkg/crane"
@@ -30,6 +31,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/yaml"
@@ -79,10 +81,45 @@ type Logger interface {
 // DefaultLogger is a quick shortcut to the slog default logger
 var DefaultLogger = Logger(slog.Default())
 
+// ImageSource describes the k8s object, and container, an image
+// reference was found in. Any of the fields may be empty if unknown
+type ImageSource struct {
+	Kind      string `json:"kind,omitempty"`      // The k8s Kind of the object
+	Namespace string `json:"namespace,omitempty"` // The namespace of the object
+	Name      string `json:"name,omitempty"`      // The name of the object
+	Container string `json:"container,omitempty"` // The name of the container
+}
+
+func imageSource(kind string, obj metav1.Object) ImageSource {
+	return ImageSource{
+		Kind:      kind,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
+}
+
+// TagResolution records that a requested tag was resolved to a more
+// specific tag (e.g. nginx:1.25 to nginx:1.25.3)
+type TagResolution struct {
+	Requested name.Tag
+	Resolved  name.Tag
+}
+
+// Step records a single decision made by a Remapper
+type Step struct {
+	Remapper string `json:"remapper"` // The name of the remapper
+	Input    string `json:"input"`    // The latest reference before the remapper ran
+	Output   string `json:"output"`   // The latest reference after the remapper ran
+	Reason   string `json:"reason"`   // Why the remapper did, or did not, change the reference
+}
+
 // History is the full set of updates performed so far
 type History struct {
-	DigestStr string
-	Refs      []name.Reference
+	Resolution *TagResolution
+	Source     ImageSource
+	DigestStr  string
+	Refs       []name.Reference
+	Steps      []Step
 }
 
 // NewHistory starts a history for a given reference
@@ -107,6 +144,21 @@ func (h *History) Add(ref name.Reference) {
 	h.Refs = append(h.Refs, ref)
 }
 
+// Record notes a decision made by the named remapper, and the reason for it.
+// If ref is not nil it is added to the history as the new latest reference
+func (h *History) Record(remapper, reason string, ref name.Reference) {
+	in := h.Latest().String()
+	if ref != nil {
+		h.Add(ref)
+	}
+	h.Steps = append(h.Steps, Step{
+		Remapper: remapper,
+		Input:    in,
+		Output:   h.Latest().String(),
+		Reason:   reason,
+	})
+}
+
 // AddDigest sets the known image digest for the image being
 // tracked by this history
 func (h *History) AddDigest(ref name.Digest) {
@@ -122,7 +174,7 @@ func (h *History) OriginalDigest() (name.Digest, error) {
 		return ref.Context().Registry.Repo(ref.Context().RepositoryStr()).Digest(h.DigestStr), nil
 	}
 
-	digestStr, err := crane.Digest(ref.String())
+	digestStr, err := crane.Digest(refString(ref))
 	if err != nil {
 		return name.Digest{}, fmt.Errorf("failed reading digest for %s, %w", ref.String(), err)
 	}
@@ -158,9 +210,112 @@ type RepoTemplateInput struct {
 	Digest     string // The digest of the image
 	DigestAlgo string // The hash algorithm of the image digest
 	DigestHex  string // The hex string of the digest hash
-	Tag        string // The image tag (TODO(tcm): not used at the moment)
+	Tag        string // The image tag, empty if the image was referenced by digest
 	Registry   string // The image registry
 	Repository string // The image repository
+	Kind       string // The k8s Kind of the object the image was found in
+	Namespace  string // The namespace of the object the image was found in
+	Name       string // The name of the object the image was found in
+	Container  string // The name of the container the image was found in
+}
+
+// TemplateFuncs are the additional functions available to rename templates.
+// Functions take the piped value as their last argument, e.g.
+// {{ .Repository | trimPrefix "library/" }}
+var TemplateFuncs = template.FuncMap{
+	"replace":    func(from, to, s string) string { return strings.ReplaceAll(s, from, to) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"truncate": func(n int, s string) string {
+		if n < 0 || len(s) <= n {
+			return s
+		}
+		return s[:n]
+	},
+	"lower": strings.ToLower,
+	"sha256sum": func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	},
+}
+
+// sourceFields are the fields of RepoTemplateInput that describe where an
+// image was found, rather than the image itself
+var sourceFields = map[string]struct{}{
+	"Kind":      {},
+	"Namespace": {},
+	"Name":      {},
+	"Container": {},
+}
+
+// TemplateUsesSource returns true if the template refers to the Kind, Namespace,
+// Name or Container fields of RepoTemplateInput. Such templates may rename the
+// same image differently for each object, or container, it is found in.
+func TemplateUsesSource(tmpl *template.Template) bool {
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && nodeUsesSource(t.Tree.Root) {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeUsesSource(n parse.Node) bool {
+	var children []parse.Node
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		children = n.Nodes
+	case *parse.ActionNode:
+		children = []parse.Node{n.Pipe}
+	case *parse.TemplateNode:
+		children = []parse.Node{n.Pipe}
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, c := range n.Cmds {
+			children = append(children, c)
+		}
+	case *parse.CommandNode:
+		children = n.Args
+	case *parse.IfNode:
+		children = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.RangeNode:
+		children = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.WithNode:
+		children = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.ChainNode:
+		if _, ok := sourceFields[n.Field[0]]; ok {
+			return true
+		}
+		children = []parse.Node{n.Node}
+	case *parse.FieldNode:
+		_, ok := sourceFields[n.Ident[0]]
+		return ok
+	case *parse.VariableNode:
+		if len(n.Ident) < 2 {
+			return false
+		}
+		_, ok := sourceFields[n.Ident[1]]
+		return ok
+	}
+
+	for _, c := range children {
+		if nodeUsesSource(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// renameKey identifies an image, and where it was found. Templates may render
+// different names for the same image found in different places.
+type renameKey struct {
+	ref string
+	src ImageSource
 }
 
 // RenameRemapper is a Remapper implementation that can rename an image to
@@ -169,7 +324,7 @@ type RepoTemplateInput struct {
 // copy the image to the new locatio
 type RenameRemapper struct {
 	Logger
-	history    map[string]string
+	history    map[renameKey]string
 	Ignore     *regexp.Regexp
 	RemoteTmpl *template.Template
 	RemotePath string
@@ -183,7 +338,12 @@ func (t *RenameRemapper) ReMap(h *History) error {
 	refCtx := ref.Context()
 
 	img := ref.String()
-	if img == "" || (t.Ignore != nil && t.Ignore.MatchString(img)) {
+	if img == "" {
+		h.Record("rename", "empty reference", nil)
+		return nil
+	}
+	if t.Ignore != nil && t.Ignore.MatchString(img) {
+		h.Record("rename", fmt.Sprintf("ignored by regexp %s", t.Ignore), nil)
 		return nil
 	}
 
@@ -196,6 +356,10 @@ func (t *RenameRemapper) ReMap(h *History) error {
 	switch r := ref.(type) {
 	case name.Digest:
 		digest = r
+		tagStr = refTag(h.Original())
+	case TaggedDigest:
+		digest = r.Digest
+		tagStr = r.TagStr()
 	case name.Tag:
 		tagStr = r.TagStr()
 	default:
@@ -212,37 +376,42 @@ func (t *RenameRemapper) ReMap(h *History) error {
 		DigestAlgo: digestAlgo,
 		DigestHex:  digestHex,
 		Tag:        tagStr,
+		Kind:       h.Source.Kind,
+		Namespace:  h.Source.Namespace,
+		Name:       h.Source.Name,
+		Container:  h.Source.Container,
 	}
 
 	newName := bytes.NewBufferString("")
 
 	err = t.RemoteTmpl.Execute(newName, input)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed rendering rename template for %s, %w", img, err)
 	}
 
-	newRef, err := name.ParseReference(newName.String())
+	newRef, err := ParseReference(newName.String())
 	if err != nil {
-		return err
+		return fmt.Errorf("rename template rendered an invalid image reference %q for %s, %w", newName.String(), img, err)
 	}
 
 	if t.history == nil {
-		t.history = map[string]string{}
+		t.history = map[renameKey]string{}
 	}
 
-	origStr := h.Original().String()
-	if existing, ok := t.history[origStr]; ok && existing != newRef.String() {
+	origStr := FormatReference(h.Original(), RefStyleFull)
+	key := renameKey{ref: origStr, src: h.Source}
+	if existing, ok := t.history[key]; ok && existing != newRef.String() {
 		return fmt.Errorf("template remapping must be one to one, cannot map %s to %s aswell as %s", origStr, existing, newRef)
 	}
 
-	t.history[origStr] = newRef.String()
-	h.Add(newRef)
+	t.history[key] = newRef.String()
+	h.Record("rename", "template rendered", newRef)
 
 	return nil
 }
 
 func needsUpdate(newRef name.Reference, old name.Digest, log Logger) (bool, error) {
-	digest, err := crane.Digest(newRef.String())
+	digest, err := crane.Digest(refString(newRef))
 
 	var terr *transport.Error
 	if errors.As(err, &terr) {
@@ -272,29 +441,53 @@ func needsUpdate(newRef name.Reference, old name.Digest, log Logger) (bool, erro
 
 // QualifiedImage describes an image tag, at a specific digest
 type QualifiedImage struct {
-	Tag         string   `json:"tag"`
-	Digest      string   `json:"digest"`
-	IgnoredCVEs []string `json:"ignoredCVEs,omitempty"`
-	FoundCVEs   []string `json:"foundCVEs,omitempty"`
+	Tag         string               `json:"tag"`
+	Digest      string               `json:"digest"`
+	ResolvedTag string               `json:"resolvedTag,omitempty"`
+	RecordedAt  *time.Time           `json:"recordedAt,omitempty"` // When the mapping was recorded
+	CheckedAt   *time.Time           `json:"checkedAt,omitempty"`  // When the image was last scanned for vulnerabilities
+	IgnoredCVEs []ImageVulnerability `json:"ignoredCVEs,omitempty"`
+	FoundCVEs   []ImageVulnerability `json:"foundCVEs,omitempty"`
+}
+
+// DigestReference returns a reference to the image by its digest
+func (qi QualifiedImage) DigestReference() (name.Digest, error) {
+	ref, err := ParseReference(qi.Tag)
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("could not parse ref %q, %w", qi.Tag, err)
+	}
+	return ref.Context().Registry.Repo(ref.Context().RepositoryStr()).Digest(qi.Digest), nil
 }
 
 // StaticRemapper is a Remapper implementation that allows statically mapping
-// incoming images to a pre-existing set of known target image names and digests
+// incoming images to a pre-existing set of known target image names and digests.
+// The keys of Mappings must be in the form returned by NormalizeReference
 type StaticRemapper struct {
 	Mappings     map[string]QualifiedImage
+	Fallback     Remapper // If set, images not in the mappings are passed to this, requires AllowMissing
 	AllowMissing bool
 }
 
 // NewStaticRemapper creates a StaticRemapper. If confirmDigest is true, the constructor
-// will check that all target image tags still map to the currently referenced digest
+// will check that all target image tags still map to the currently referenced digest.
+// Mapping keys are normalised, it is an error for equivalent keys to have different
+// mappings.
 func NewStaticRemapper(mps map[string]QualifiedImage, confirmDigest bool) (*StaticRemapper, error) {
+	res := map[string]QualifiedImage{}
+	keys := map[string]string{}
 	for k, v := range mps {
-		_, err := name.ParseReference(k)
+		key, err := NormalizeReference(k)
 		if err != nil {
 			return nil, fmt.Errorf("could not parse mapping key %s, %w", k, err)
 		}
 
-		_, err = name.ParseReference(v.Tag)
+		if orig, ok := keys[key]; ok && (res[key].Tag != v.Tag || res[key].Digest != v.Digest) {
+			return nil, fmt.Errorf("mapping keys %s and %s refer to the same image, but are mapped differently", orig, k)
+		}
+		keys[key] = k
+		res[key] = v
+
+		tag, err := ParseReference(v.Tag)
 		if err != nil {
 			return nil, fmt.Errorf("could not parse mapping value %s, %w", v.Tag, err)
 		}
@@ -302,7 +495,7 @@ func NewStaticRemapper(mps map[string]QualifiedImage, confirmDigest bool) (*Stat
 		if !confirmDigest {
 			continue
 		}
-		dig, err := crane.Digest(v.Tag)
+		dig, err := crane.Digest(refString(tag))
 		if err != nil {
 			return nil, fmt.Errorf("could not check digest for %s, %w", v.Tag, err)
 		}
@@ -311,30 +504,35 @@ func NewStaticRemapper(mps map[string]QualifiedImage, confirmDigest bool) (*Stat
 		}
 	}
 
-	return &StaticRemapper{Mappings: mps}, nil
+	return &StaticRemapper{Mappings: res}, nil
 }
 
 // ReMap looks up the incoming image in the provided mappings. If AllowMissing is
 // false, attempts to look up images not in the static mappings will fail (if true,
-// ReMap is a no-op)
+// the image is passed to the Fallback, or ReMap is a no-op if there is none)
 func (s *StaticRemapper) ReMap(h *History) error {
-	refStr := h.Latest().String()
+	refStr := FormatReference(h.Latest(), RefStyleFull)
 	staticDetails, ok := s.Mappings[refStr]
 	if !ok {
 		if s.AllowMissing {
+			if s.Fallback != nil {
+				h.Record("static", "not in static mappings, using fallback", nil)
+				return s.Fallback.ReMap(h)
+			}
+			h.Record("static", "not in static mappings, passed through", nil)
 			return nil
 		}
 		return fmt.Errorf("no known static reference for %s", refStr)
 	}
-	newRef, _ := name.ParseReference(staticDetails.Tag)
-	h.Add(newRef)
+	newRef, _ := ParseReference(staticDetails.Tag)
+	h.Record("static", "static mapping hit", newRef)
 	digRef := newRef.Context().Registry.Repo(newRef.Context().RepositoryStr()).Digest(staticDetails.Digest)
 	h.AddDigest(digRef)
 	return nil
 }
 
-// EnsureRemapper is a mapper that will copy the original image reference
-// to the latest, possibly remote, reference
+// EnsureRemapper is a mapper that will copy the original image, at the
+// digest recorded in the history, to the latest, possibly remote, reference
 type EnsureRemapper struct {
 	Logger
 
@@ -344,30 +542,36 @@ type EnsureRemapper struct {
 
 // ReMap copies the original reference to the latest, potentially remote reference
 func (t *EnsureRemapper) ReMap(h *History) error {
-	srcRef := h.Original()
 	newRef := h.Latest()
 	digest, err := h.OriginalDigest()
 	if err != nil {
 		return fmt.Errorf("ensure remapper failed to look up the digest, %w", err)
 	}
+	srcRef := digest
 
 	update, err := needsUpdate(newRef, digest, t)
 	if err != nil {
 		return err
 	}
 
-	if update {
-		if t.DryRun {
-			if t.Logger != nil {
-				t.Info("dry-run, skipping copy", slog.String("src", srcRef.String()), slog.String("dst", newRef.String()))
-			}
-			return nil
-		}
-		err = crane.Copy(srcRef.String(), newRef.String(), crane.WithNoClobber(t.NoClobber))
-		if err != nil {
-			return err
+	if !update {
+		h.Record("ensure", "already present remotely", nil)
+		return nil
+	}
+
+	if t.DryRun {
+		if t.Logger != nil {
+			t.Info("dry-run, skipping copy", slog.String("src", srcRef.String()), slog.String("dst", newRef.String()))
 		}
+		h.Record("ensure", "dry-run, copy skipped", nil)
+		return nil
+	}
+
+	err = crane.Copy(srcRef.String(), refString(newRef), crane.WithNoClobber(t.NoClobber))
+	if err != nil {
+		return err
 	}
+	h.Record("ensure", fmt.Sprintf("copied from %s", srcRef), nil)
 
 	return nil
 }
@@ -387,6 +591,7 @@ type IgnoreRemapper struct {
 func (t *IgnoreRemapper) ReMap(h *History) error {
 	name := h.Latest().Name()
 	if t.Ignore != nil && t.Ignore.MatchString(name) {
+		h.Record("ignore", fmt.Sprintf("ignored by regexp %s", t.Ignore), nil)
 		return ErrSkip
 	}
 	return nil
@@ -411,7 +616,9 @@ func (t MultiRemapper) ReMap(h *History) error {
 
 // RecorderRemapper records all remappings up as they are seen
 type RecorderRemapper struct {
+	now       func() time.Time // for testing
 	histories []*History
+	KeyStyle  RefStyle // The style used for the keys of the returned mappings
 }
 
 // ReMap records all remappings so far, should usuually be used as the final
@@ -422,25 +629,39 @@ func (r *RecorderRemapper) ReMap(h *History) error {
 }
 
 // Mappings returns the set of image original to final performed by
-// all the remappers
+// all the remappers. The keys are the normalised original references,
+// formatted using KeyStyle
 func (r *RecorderRemapper) Mappings() (map[string]QualifiedImage, error) {
 	res := map[string]QualifiedImage{}
+	targets := map[string]string{}
+
+	now := time.Now()
+	if r.now != nil {
+		now = r.now()
+	}
 
 	for _, h := range r.histories {
-		org := h.Original()
+		org := FormatReference(h.Original(), r.KeyStyle)
 		last := h.Latest()
 		lastDig, err := h.OriginalDigest()
 		if err != nil {
 			return nil, fmt.Errorf("failed to record digest, %w", err)
 		}
+		recorded := now.UTC()
 		lastImg := QualifiedImage{
-			Tag:    last.String(),
-			Digest: lastDig.DigestStr(),
+			Tag:        last.String(),
+			Digest:     lastDig.DigestStr(),
+			RecordedAt: &recorded,
+		}
+		if h.Resolution != nil {
+			lastImg.ResolvedTag = h.Resolution.Resolved.String()
 		}
-		if foundStr, ok := res[org.String()]; ok && ((foundStr.Tag != lastImg.Tag) || (foundStr.Digest != lastImg.Digest)) {
+		lastKey := FormatReference(last, RefStyleFull)
+		if foundStr, ok := res[org]; ok && ((targets[org] != lastKey) || (foundStr.Digest != lastImg.Digest)) {
 			return nil, fmt.Errorf("remapping must be one to one, cannot map %s to %s aswell as %s", org, foundStr.Digest, lastImg.Digest)
 		}
-		res[org.String()] = lastImg
+		res[org] = lastImg
+		targets[org] = lastKey
 	}
 
 	return res, nil
@@ -459,23 +680,32 @@ type ImagesFinder interface {
 // For Objects of unknown types the UnstructuredImagesFinder is used.
 // TODO(tcm): rename this thinger.
 type RenameUpdater struct {
-	Ignore       *regexp.Regexp // Completely ignore images strings matching this regexp
+	Ignore       *regexp.Regexp   // Completely ignore images strings matching this regexp
+	Tracer       *TracingRemapper // If set, images matching Ignore are recorded, so that they are explained
 	ImagesFinder ImagesFinder
 	Remapper     Remapper
 	ForceDigests bool
+	KeepTags     bool // When forcing digests, keep the tag alongside the digest (repo:tag@sha256:...)
 }
 
-func (s *RenameUpdater) remapImageString(img string) (string, error) {
+func (s *RenameUpdater) remapImageString(img string, src ImageSource) (string, error) {
 	if s.Ignore != nil && s.Ignore.MatchString(img) {
+		// ignored images that cannot be parsed are not explained
+		if ref, err := ParseReference(img); err == nil && s.Tracer != nil {
+			h := NewHistory(ref)
+			h.Source = src
+			s.Tracer.Ignored(h, s.Ignore)
+		}
 		return img, nil
 	}
 
-	ref, err := name.ParseReference(img)
+	ref, err := ParseReference(img)
 	if err != nil {
 		return "", fmt.Errorf("could not parse image ref %s, %w", img, err)
 	}
 
 	h := NewHistory(ref)
+	h.Source = src
 
 	err = s.Remapper.ReMap(h)
 	if errors.Is(ErrSkip, err) {
@@ -494,12 +724,17 @@ func (s *RenameUpdater) remapImageString(img string) (string, error) {
 		return "", fmt.Errorf("could not rename %s to digest, %w", img, err)
 	}
 
+	if tag := refTag(h.Latest()); s.KeepTags && tag != "" {
+		return NewTaggedDigest(dig, tag).String(), nil
+	}
+
 	return dig.String(), nil
 }
 
-func (s *RenameUpdater) processContainers(cnts []corev1.Container) error {
+func (s *RenameUpdater) processContainers(cnts []corev1.Container, src ImageSource) error {
 	for i, c := range cnts {
-		newImg, err := s.remapImageString(c.Image)
+		src.Container = c.Name
+		newImg, err := s.remapImageString(c.Image, src)
 		if err != nil {
 			return err
 		}
@@ -511,13 +746,13 @@ func (s *RenameUpdater) processContainers(cnts []corev1.Container) error {
 	return nil
 }
 
-func (s *RenameUpdater) processPodSpec(spec *corev1.PodSpec) error {
+func (s *RenameUpdater) processPodSpec(spec *corev1.PodSpec, src ImageSource) error {
 	var err error
-	err = s.processContainers(spec.Containers)
+	err = s.processContainers(spec.Containers, src)
 	if err != nil {
 		return fmt.Errorf("failed processing container, %w", err)
 	}
-	err = s.processContainers(spec.InitContainers)
+	err = s.processContainers(spec.InitContainers, src)
 	if err != nil {
 		return fmt.Errorf("failed processing init container, %w", err)
 	}
@@ -529,8 +764,13 @@ func (s *RenameUpdater) processUnstructured(obj *unstructured.Unstructured) erro
 	if err != nil {
 		return err
 	}
+	src := ImageSource{
+		Kind:      obj.GetKind(),
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
 	for img, setters := range matches {
-		newImg, err := s.remapImageString(img)
+		newImg, err := s.remapImageString(img, src)
 		if err != nil {
 			return err
 		}
@@ -546,7 +786,7 @@ func (s *RenameUpdater) processRaw(obj any) error {
 		return err
 	}
 	for img, setters := range matches {
-		newImg, err := s.remapImageString(img)
+		newImg, err := s.remapImageString(img, ImageSource{})
 		if err != nil {
 			return err
 		}
@@ -572,71 +812,71 @@ func (s *RenameUpdater) Update(obj any) error {
 	case *RawYAML:
 		return s.processRaw(t.Object)
 	case *corev1.Pod:
-		return s.processPodSpec(&t.Spec)
+		return s.processPodSpec(&t.Spec, imageSource("Pod", t))
 	case *corev1.PodList:
 		for i, l := range t.Items {
 			p := l
-			if err := s.processPodSpec(&p.Spec); err != nil {
+			if err := s.processPodSpec(&p.Spec, imageSource("Pod", &p)); err != nil {
 				return err
 			}
 			t.Items[i] = p
 		}
 	case *appsv1.ReplicaSet:
-		return s.processPodSpec(&t.Spec.Template.Spec)
+		return s.processPodSpec(&t.Spec.Template.Spec, imageSource("ReplicaSet", t))
 	case *appsv1.ReplicaSetList:
 		for i, l := range t.Items {
 			p := l
-			if err := s.processPodSpec(&p.Spec.Template.Spec); err != nil {
+			if err := s.processPodSpec(&p.Spec.Template.Spec, imageSource("ReplicaSet", &p)); err != nil {
 				return err
 			}
 			t.Items[i] = p
 		}
 	case *appsv1.DaemonSet:
-		return s.processPodSpec(&t.Spec.Template.Spec)
+		return s.processPodSpec(&t.Spec.Template.Spec, imageSource("DaemonSet", t))
 	case *appsv1.DaemonSetList:
 		for i, l := range t.Items {
 			p := l
-			if err := s.processPodSpec(&p.Spec.Template.Spec); err != nil {
+			if err := s.processPodSpec(&p.Spec.Template.Spec, imageSource("DaemonSet", &p)); err != nil {
 				return err
 			}
 			t.Items[i] = p
 		}
 	case *appsv1.Deployment:
-		return s.processPodSpec(&t.Spec.Template.Spec)
+		return s.processPodSpec(&t.Spec.Template.Spec, imageSource("Deployment", t))
 	case *appsv1.DeploymentList:
 		for i, l := range t.Items {
 			p := l
-			if err := s.processPodSpec(&p.Spec.Template.Spec); err != nil {
+			if err := s.processPodSpec(&p.Spec.Template.Spec, imageSource("Deployment", &p)); err != nil {
 				return err
 			}
 			t.Items[i] = p
 		}
 	case *appsv1.StatefulSet:
-		return s.processPodSpec(&t.Spec.Template.Spec)
+		return s.processPodSpec(&t.Spec.Template.Spec, imageSource("StatefulSet", t))
 	case *appsv1.StatefulSetList:
 		for i, l := range t.Items {
 			p := l
-			if err := s.processPodSpec(&p.Spec.Template.Spec); err != nil {
+			if err := s.processPodSpec(&p.Spec.Template.Spec, imageSource("StatefulSet", &p)); err != nil {
 				return err
 			}
 			t.Items[i] = p
 		}
 	case *batchv1.Job:
-		return s.processPodSpec(&t.Spec.Template.Spec)
+		return s.processPodSpec(&t.Spec.Template.Spec, imageSource("Job", t))
 	case *batchv1.JobList:
 		for i, l := range t.Items {
 			p := l
-			if err := s.processPodSpec(&p.Spec.Template.Spec); err != nil {
+			if err := s.processPodSpec(&p.Spec.Template.Spec, imageSource("Job", &p)); err != nil {
 				return err
 			}
 			t.Items[i] = p
 		}
 	case *batchv1.CronJob:
-		return s.processPodSpec(&t.Spec.JobTemplate.Spec.Template.Spec)
+		return s.processPodSpec(&t.Spec.JobTemplate.Spec.Template.Spec, imageSource("CronJob", t))
 	case *batchv1.CronJobList:
 		for i, l := range t.Items {
 			p := l
-			if err := s.processPodSpec(&p.Spec.JobTemplate.Spec.Template.Spec); err != nil {
+			if err := s.processPodSpec(&p.Spec.JobTemplate.Spec.Template.Spec, imageSource("CronJob", &p)); err != nil {
 				return err
 			}
 			t.Items[i] = p
@@ -872,112 +1112,3 @@ func CompileJSONImageFinders(jmCfgs []JSONImageFinderConfig) (ImagesFinder, erro
 	}
 	return jms, nil
 }
-
-// VulnGetter is an interface to any tool that can retrieve vulnerabilities for
-// a given docker image digest
-type VulnGetter interface {
-	GetVulnerabilities(ctx context.Context, dig name.Digest) ([]ImageVulnerability, error)
-}
-
-// VulnChecker checks that images have been scanned, and checks that
-// they do not contain unexpected vulnerabilities
-type VulnChecker struct {
-	Getter VulnGetter
-	Logger
-	IgnoreImages  *regexp.Regexp
-	cveAllowList  map[string]struct{}
-	CVEIgnoreList []string
-	sync.Mutex
-	MaxCVSS float32
-}
-
-// ImageCheckError is returned by Check if unwanted vulnerabilities are found
-type ImageCheckError struct {
-	CVEs    map[string]float32
-	Image   string
-	MaxCVSS float32
-}
-
-func (ice *ImageCheckError) Error() string {
-	cvsStrs := []string{}
-	for cve, score := range ice.CVEs {
-		cvsStrs = append(cvsStrs, fmt.Sprintf("%s(%.2f)", cve, score))
-	}
-	sort.Strings(cvsStrs)
-
-	str := fmt.Sprintf(
-		"image %s has %d CVEs with score > %.2f: %s",
-		ice.Image,
-		len(ice.CVEs),
-		ice.MaxCVSS,
-		strings.Join(cvsStrs, ","),
-	)
-
-	return str
-}
-
-// ImageVulnerability describes a given CVE by ID and score
-type ImageVulnerability struct {
-	ID   string
-	CVSS float32
-}
-
-// VulnCheckResult is the result of a vulnerability check
-type VulnCheckResult struct {
-	Ignored []string // CVEs that were present, but explicitly ignored by the checker
-	Found   []string // CVEs that were present, but under the max requested CVSS
-}
-
-// Check waits for a completed vulnerability discovery, and then check that an image
-// has no CVEs that violate the configured policy
-func (vc *VulnChecker) Check(ctx context.Context, dig name.Digest) (*VulnCheckResult, error) {
-	var err error
-	img := dig.String()
-	if vc.IgnoreImages != nil && vc.IgnoreImages.MatchString(img) {
-		return &VulnCheckResult{}, nil
-	}
-
-	vc.Lock()
-	if vc.cveAllowList == nil {
-		vc.cveAllowList = map[string]struct{}{}
-		for _, str := range vc.CVEIgnoreList {
-			vc.cveAllowList[str] = struct{}{}
-		}
-	}
-	vc.Unlock()
-
-	res := VulnCheckResult{}
-	cves, err := vc.Getter.GetVulnerabilities(ctx, dig)
-	if err != nil {
-		return nil, err
-	}
-
-	if vc.MaxCVSS == 0 {
-		return &res, nil
-	}
-
-	badCVEs := map[string]float32{}
-	for _, cve := range cves {
-		score := cve.CVSS
-		cve := cve.ID
-		if score > vc.MaxCVSS {
-			if _, ok := vc.cveAllowList[cve]; ok {
-				res.Ignored = append(res.Ignored, fmt.Sprintf("%s:%f", cve, score))
-				continue
-			}
-			badCVEs[cve] = score
-			continue
-		}
-		res.Found = append(res.Found, fmt.Sprintf("%s:%f", cve, score))
-	}
-
-	if len(badCVEs) != 0 {
-		return nil, &ImageCheckError{
-			Image:   dig.Name(),
-			MaxCVSS: vc.MaxCVSS,
-			CVEs:    badCVEs,
-		}
-	}
-
-	return &res, nil
-}
@@ -20,6 +20,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -372,6 +373,48 @@ func newTestRegistryLogger(t *testing.T) registry.Option {
 	return registry.Logger(log.New(rtl, "", 0))
 }
 
+// newTestRegistry starts a registry, with referrers support, for the
+// duration of the test, and returns its host
+func newTestRegistry(t *testing.T) string {
+	t.Helper()
+	s := httptest.NewServer(registry.New(newTestRegistryLogger(t), registry.WithReferrersSupport(true)))
+	t.Cleanup(s.Close)
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+// pushImage pushes the image to the reference, and returns a reference
+// to it by digest
+func pushImage(t *testing.T, ref string, img v1.Image) name.Digest {
+	t.Helper()
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Write(r, img); err != nil {
+		t.Fatal(err)
+	}
+	h, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.Context().Digest(h.String())
+}
+
+// pushRandomImage pushes a random image to the reference, and returns a
+// reference to it by digest
+func pushRandomImage(t *testing.T, ref string) name.Digest {
+	t.Helper()
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pushImage(t, ref, img)
+}
+
 func TestRenameRemapper(t *testing.T) {
 	base := "example.com/firstrepo/test/img1"
 	tagStr := fmt.Sprintf("%s:latest", base)
@@ -445,6 +488,179 @@ func TestRenameRemapper_Ignore(t *testing.T) {
 	}
 }
 
+func TestRenameRemapper_TemplateFuncs(t *testing.T) {
+	base := "index.docker.io/library/nginx"
+	tagStr := fmt.Sprintf("%s:1.25", base)
+	hashStr := "abcdabcdabceabcdabcdabcdabcdabcdabcdabcdabcaacbcbfedabcaefacbaea"
+
+	digStr := fmt.Sprintf("%s@sha256:%s", base, hashStr)
+
+	tagRef, err := name.ParseReference(tagStr)
+	if err != nil {
+		t.Fatalf("test borked, %v", err)
+	}
+	digRef, err := name.ParseReference(digStr)
+	if err != nil {
+		t.Fatalf("test borked digest, %v", err)
+	}
+	h := NewHistory(tagRef)
+	h.AddDigest(digRef.(name.Digest))
+	h.Source = ImageSource{Kind: "Deployment", Namespace: "web", Name: "Frontend", Container: "proxy"}
+
+	tmpl := template.Must(template.New("test").Funcs(TemplateFuncs).Parse(
+		`{{ .RemotePath }}/{{ .Namespace }}/{{ .Name | lower }}/{{ .Repository | trimPrefix "library/" }}:{{ .Tag | replace "." "-" }}-{{ truncate 12 .DigestHex }}`,
+	))
+
+	rr := &RenameRemapper{
+		RemotePath: "example.com/imported",
+		RemoteTmpl: tmpl,
+	}
+
+	exp := "example.com/imported/web/frontend/nginx:1-25-abcdabcdabce"
+
+	err = rr.ReMap(h)
+	if err != nil {
+		t.Fatalf("remap failed, %v", err)
+	}
+	newTag := h.Latest()
+	if newTag.String() != exp {
+		t.Fatalf("incorred latest tag:\n  got: %s\n  exp: %s\n", newTag.String(), exp)
+	}
+}
+
+func TestRenameRemapper_InvalidTemplateOutput(t *testing.T) {
+	hashStr := "abcdabcdabceabcdabcdabcdabcdabcdabcdabcdabcaacbcbfedabcaefacbaea"
+	tagRef, _ := name.ParseReference("example.com/firstrepo/test/img1:latest")
+	h := NewHistory(tagRef)
+	h.AddDigest(tagRef.Context().Digest("sha256:" + hashStr))
+
+	rr := &RenameRemapper{
+		RemoteTmpl: template.Must(template.New("test").Parse(`{{ .RemotePath }}/{{ .Repository }}:not a tag`)),
+	}
+
+	err := rr.ReMap(h)
+	if err == nil {
+		t.Fatalf("expected invalid template output to fail")
+	}
+	t.Logf("invalid template output error, %v", err)
+}
+
+func TestTemplateUsesSource(t *testing.T) {
+	var tests = []struct {
+		tmpl string
+		exp  bool
+	}{
+		{DefaultTemplateStr, false},
+		{`{{ .RemotePath }}/{{ .Namespace }}/{{ .Repository }}`, true},
+		{`{{ .RemotePath }}/{{ .Repository }}:{{ .Tag }}-{{ .Container | lower }}`, true},
+		{`{{ .RemotePath }}/{{ if eq .Kind "Job" }}jobs/{{ end }}{{ .Repository }}`, true},
+		{`{{ .RemotePath }}/{{ $.Name }}/{{ .Repository }}`, true},
+		{`{{ .RemotePath }}/{{ with .Registry }}{{ . }}{{ end }}/{{ .Repository }}`, false},
+	}
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			tmpl := template.Must(template.New("test").Funcs(TemplateFuncs).Parse(tt.tmpl))
+			if got := TemplateUsesSource(tmpl); got != tt.exp {
+				t.Fatalf("incorrect result for %s, got %v, exp %v", tt.tmpl, got, tt.exp)
+			}
+		})
+	}
+}
+
+// digestAdder records a fixed digest for each image, so that it is not looked up
+type digestAdder string
+
+func (d digestAdder) ReMap(h *History) error {
+	h.AddDigest(h.Original().Context().Digest(string(d)))
+	return nil
+}
+
+func TestRenameUpdater_TemplateSource(t *testing.T) {
+	hashStr := "abcdabcdabceabcdabcdabcdabcdabcdabcdabcdabcaacbcbfedabcaefacbaea"
+	in := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: frontend
+  namespace: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: proxy
+        image: nginx:1.25
+      - name: sidecar
+        image: nginx:1.25
+`
+
+	rr := &RenameRemapper{
+		RemotePath: "example.com/imported",
+		RemoteTmpl: template.Must(template.New("test").Parse(`{{ .RemotePath }}/{{ .Container }}/{{ .Repository }}:{{ .DigestHex }}`)),
+	}
+	ru := &RenameUpdater{Remapper: MultiRemapper{digestAdder("sha256:" + hashStr), rr}}
+
+	out := bytes.NewBuffer([]byte{})
+	err := ProcessK8s(out, bytes.NewBufferString(in), ru)
+	if err != nil {
+		t.Fatalf("process failed, %v", err)
+	}
+
+	for _, exp := range []string{
+		"example.com/imported/proxy/library/nginx:" + hashStr,
+		"example.com/imported/sidecar/library/nginx:" + hashStr,
+	} {
+		if !strings.Contains(out.String(), exp) {
+			t.Fatalf("expected %s in output:\n%s", exp, out)
+		}
+	}
+}
+
+type sourceRecorder struct {
+	sources map[string]ImageSource
+}
+
+func (sr *sourceRecorder) ReMap(h *History) error {
+	sr.sources[h.Original().String()] = h.Source
+	return nil
+}
+
+func TestRenameUpdater_ImageSource(t *testing.T) {
+	in := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: frontend
+  namespace: web
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: busybox:1.36
+      containers:
+      - name: proxy
+        image: nginx:1.25
+`
+	sr := &sourceRecorder{sources: map[string]ImageSource{}}
+	ru := &RenameUpdater{Remapper: sr}
+
+	out := bytes.NewBuffer([]byte{})
+	err := ProcessK8s(out, bytes.NewBufferString(in), ru)
+	if err != nil {
+		t.Fatalf("process failed, %v", err)
+	}
+
+	exp := map[string]ImageSource{
+		"busybox:1.36": {Kind: "Deployment", Namespace: "web", Name: "frontend", Container: "init"},
+		"nginx:1.25":   {Kind: "Deployment", Namespace: "web", Name: "frontend", Container: "proxy"},
+	}
+	for img, src := range exp {
+		if sr.sources[img] != src {
+			t.Fatalf("incorrect source for %s:\n  got: %#v\n  exp: %#v\n", img, sr.sources[img], src)
+		}
+	}
+}
+
 func TestEnsureRemapper(t *testing.T) {
 	rl := newTestRegistryLogger(t)
 	s1 := httptest.NewServer(registry.New(rl))
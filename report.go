@@ -0,0 +1,306 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifRule struct {
+	Properties       map[string]string `json:"properties,omitempty"`
+	ID               string            `json:"id"`
+	ShortDescription sarifMessage      `json:"shortDescription"`
+	HelpURI          string            `json:"helpUri,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+	} `json:"physicalLocation"`
+}
+
+type sarifSuppression struct {
+	Kind          string `json:"kind"`
+	Justification string `json:"justification,omitempty"`
+}
+
+type sarifResult struct {
+	RuleID       string             `json:"ruleId"`
+	Level        string             `json:"level"`
+	Message      sarifMessage       `json:"message"`
+	Locations    []sarifLocation    `json:"locations"`
+	Suppressions []sarifSuppression `json:"suppressions,omitempty"`
+}
+
+type sarifNotification struct {
+	Level   string       `json:"level"`
+	Message sarifMessage `json:"message"`
+}
+
+type sarifInvocation struct {
+	Notifications       []sarifNotification `json:"toolExecutionNotifications,omitempty"`
+	ExecutionSuccessful bool                `json:"executionSuccessful"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name           string      `json:"name"`
+			InformationURI string      `json:"informationUri"`
+			Rules          []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results     []sarifResult     `json:"results"`
+	Invocations []sarifInvocation `json:"invocations"`
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+// sortedChecks returns the keys of the checks, sorted
+func sortedChecks(checks map[string]ImageCheck) []string {
+	srcs := make([]string, 0, len(checks))
+	for src := range checks {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+	return srcs
+}
+
+// vulnDescription describes the vulnerable package, any fix, and any known exploits
+func vulnDescription(cve ImageVulnerability) string {
+	var str string
+	if cve.Package != "" {
+		str = fmt.Sprintf(" in %s %s", cve.Package, cve.Version)
+	}
+	if cve.FixedVersion != "" {
+		str += fmt.Sprintf(", fixed in %s", cve.FixedVersion)
+	}
+	if cve.Exploited != "" {
+		str += fmt.Sprintf(", exploited: %s", cve.Exploited)
+	}
+	return str
+}
+
+// WriteSARIF writes a SARIF log of the vulnerability checks, with one result for
+// each vulnerability found in each image. Vulnerabilities that failed the check are
+// reported as errors, others as warnings, and ignored vulnerabilities are reported
+// as suppressed, with their justification. Images that could not be checked are
+// reported as tool execution notifications.
+func WriteSARIF(w io.Writer, checks map[string]ImageCheck) error {
+	run := sarifRun{Results: []sarifResult{}}
+	run.Tool.Driver.Name = "reimage"
+	run.Tool.Driver.InformationURI = "https://github.com/cerbos/reimage"
+
+	rules := map[string]sarifRule{}
+	inv := sarifInvocation{ExecutionSuccessful: true}
+
+	for _, src := range sortedChecks(checks) {
+		check := checks[src]
+		ice := &ImageCheckError{}
+		if check.Err != nil && !errors.As(check.Err, &ice) {
+			inv.ExecutionSuccessful = false
+			inv.Notifications = append(inv.Notifications, sarifNotification{
+				Level:   "error",
+				Message: sarifMessage{Text: fmt.Sprintf("could not check %s, %v", src, check.Err)},
+			})
+			continue
+		}
+		if check.Result == nil {
+			continue
+		}
+
+		loc := sarifLocation{}
+		loc.PhysicalLocation.ArtifactLocation.URI = check.Image.Tag
+
+		add := func(cve ImageVulnerability, level, status string) {
+			if _, ok := rules[cve.ID]; !ok {
+				rule := sarifRule{
+					ID:               cve.ID,
+					ShortDescription: sarifMessage{Text: cve.ID + vulnDescription(cve)},
+					Properties:       map[string]string{},
+				}
+				if strings.HasPrefix(cve.ID, "CVE-") {
+					rule.HelpURI = "https://nvd.nist.gov/vuln/detail/" + cve.ID
+				}
+				if cve.CVSS != 0 {
+					// GitHub code scanning uses this to rank results
+					rule.Properties["security-severity"] = fmt.Sprintf("%.1f", cve.CVSS)
+				}
+				if cve.Severity != "" {
+					rule.Properties["severity"] = cve.Severity
+				}
+				rules[cve.ID] = rule
+			}
+
+			res := sarifResult{
+				RuleID:    cve.ID,
+				Level:     level,
+				Message:   sarifMessage{Text: fmt.Sprintf("%s %s %s%s", src, status, cve, vulnDescription(cve))},
+				Locations: []sarifLocation{loc},
+			}
+			if level == "note" {
+				res.Suppressions = []sarifSuppression{{Kind: "external", Justification: cve.Justification}}
+			}
+			run.Results = append(run.Results, res)
+		}
+
+		for _, cve := range check.Result.Failed {
+			add(cve, "error", "fails the vulnerability policy with")
+		}
+		for _, cve := range check.Result.Found {
+			add(cve, "warning", "has")
+		}
+		for _, cve := range check.Result.Ignored {
+			add(cve, "note", "has ignored")
+		}
+	}
+
+	ids := make([]string, 0, len(rules))
+	for id := range rules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	run.Tool.Driver.Rules = []sarifRule{}
+	for _, id := range ids {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, rules[id])
+	}
+	run.Invocations = []sarifInvocation{inv}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs:    []sarifRun{run},
+	})
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+type junitTestCase struct {
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	XMLName   xml.Name      `xml:"testcase"`
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+// vulnList lists the vulnerabilities, one per line
+func vulnList(cves []ImageVulnerability, withJustification bool) string {
+	var sb strings.Builder
+	for _, cve := range cves {
+		sb.WriteString(cve.String())
+		sb.WriteString(vulnDescription(cve))
+		if withJustification && cve.Justification != "" {
+			sb.WriteString(": ")
+			sb.WriteString(cve.Justification)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// WriteJUnit writes a JUnit XML report of the vulnerability checks, with one test
+// case for each image. Images with vulnerabilities that violate the policy are
+// reported as failures, images that could not be checked as errors, and images
+// that matched IgnoreImages as skipped. The found and ignored vulnerabilities
+// are listed in each test case's output.
+func WriteJUnit(w io.Writer, checks map[string]ImageCheck) error {
+	suite := junitTestSuite{Name: "reimage vulnerability check"}
+
+	for _, src := range sortedChecks(checks) {
+		check := checks[src]
+		tc := junitTestCase{
+			Name:      src,
+			ClassName: check.Image.Tag,
+		}
+
+		ice := &ImageCheckError{}
+		switch {
+		case check.Err != nil && errors.As(check.Err, &ice):
+			suite.Failures++
+			tc.Failure = &junitFailure{Message: check.Err.Error(), Type: "vulnerabilities"}
+			if check.Result != nil {
+				tc.Failure.Text = vulnList(check.Result.Failed, false)
+			}
+		case check.Err != nil:
+			suite.Errors++
+			tc.Error = &junitFailure{Message: check.Err.Error()}
+		case check.Result != nil && check.Result.Skipped:
+			suite.Skipped++
+			tc.Skipped = &junitSkipped{Message: "image matched the ignored images"}
+		}
+
+		if check.Result != nil {
+			var out string
+			if len(check.Result.Found) != 0 {
+				out += "found:\n" + vulnList(check.Result.Found, false)
+			}
+			if len(check.Result.Ignored) != 0 {
+				out += "ignored:\n" + vulnList(check.Result.Ignored, true)
+			}
+			tc.SystemOut = out
+		}
+
+		suite.TestCases = append(suite.TestCases, tc)
+		suite.Tests++
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,121 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testImageChecks() map[string]ImageCheck {
+	return map[string]ImageCheck{
+		"nginx:1.25": {
+			Image: QualifiedImage{Tag: "mirror.example.com/nginx:1.25", Digest: testDigestStr},
+			Result: &VulnCheckResult{
+				Found:   []ImageVulnerability{{ID: "CVE-1", CVSS: 5.0, Severity: "MEDIUM", Package: "openssl", Version: "3.0.1", FixedVersion: "3.0.2"}},
+				Ignored: []ImageVulnerability{{ID: "CVE-2", CVSS: 9.0, Justification: "not reachable"}},
+			},
+		},
+		"redis:7.2.4": {
+			Image: QualifiedImage{Tag: "mirror.example.com/redis:7.2.4", Digest: otherTestDigestStr},
+			Result: &VulnCheckResult{
+				Failed: []ImageVulnerability{{ID: "CVE-3", CVSS: 9.8, Severity: "CRITICAL"}},
+				Found:  []ImageVulnerability{{ID: "CVE-1", CVSS: 5.0, Severity: "MEDIUM"}},
+			},
+			Err: &ImageCheckError{Image: "mirror.example.com/redis", MaxCVSS: 7.0, CVEs: map[string]float32{"CVE-3": 9.8}},
+		},
+		"busybox:1.36": {
+			Image:  QualifiedImage{Tag: "mirror.example.com/busybox:1.36"},
+			Result: &VulnCheckResult{Skipped: true},
+		},
+		"alpine:3.20": {
+			Image: QualifiedImage{Tag: "mirror.example.com/alpine:3.20"},
+			Err:   errors.New("scanner failed"),
+		},
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteSARIF(buf, testImageChecks()); err != nil {
+		t.Fatalf("could not write sarif, %v", err)
+	}
+
+	log := sarifLog{}
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatalf("could not parse sarif, %v", err)
+	}
+	if log.Version != sarifVersion || len(log.Runs) != 1 {
+		t.Fatalf("incorrect sarif log, got %s", buf)
+	}
+	run := log.Runs[0]
+
+	var got []string
+	for _, r := range run.Results {
+		got = append(got, r.RuleID+":"+r.Level+":"+r.Locations[0].PhysicalLocation.ArtifactLocation.URI)
+	}
+	exp := []string{
+		"CVE-1:warning:mirror.example.com/nginx:1.25",
+		"CVE-2:note:mirror.example.com/nginx:1.25",
+		"CVE-3:error:mirror.example.com/redis:7.2.4",
+		"CVE-1:warning:mirror.example.com/redis:7.2.4",
+	}
+	if strings.Join(got, ",") != strings.Join(exp, ",") {
+		t.Fatalf("incorrect results\n  got: %v\n  exp: %v", got, exp)
+	}
+	if sup := run.Results[1].Suppressions; len(sup) != 1 || sup[0].Justification != "not reachable" {
+		t.Fatalf("expected ignored vulnerability to be suppressed, got %+v", sup)
+	}
+
+	rules := run.Tool.Driver.Rules
+	if len(rules) != 3 || rules[2].ID != "CVE-3" || rules[2].Properties["security-severity"] != "9.8" {
+		t.Fatalf("incorrect rules, got %+v", rules)
+	}
+
+	inv := run.Invocations[0]
+	if inv.ExecutionSuccessful || len(inv.Notifications) != 1 || !strings.Contains(inv.Notifications[0].Message.Text, "alpine:3.20") {
+		t.Fatalf("expected alpine check error to be reported, got %+v", inv)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteJUnit(buf, testImageChecks()); err != nil {
+		t.Fatalf("could not write junit, %v", err)
+	}
+
+	suites := junitTestSuites{}
+	if err := xml.Unmarshal(buf.Bytes(), &suites); err != nil {
+		t.Fatalf("could not parse junit, %v", err)
+	}
+	if len(suites.Suites) != 1 {
+		t.Fatalf("expected one test suite, got %s", buf)
+	}
+	suite := suites.Suites[0]
+	if suite.Tests != 4 || suite.Failures != 1 || suite.Errors != 1 || suite.Skipped != 1 {
+		t.Fatalf("incorrect counts, got %+v", suite)
+	}
+
+	tcs := map[string]junitTestCase{}
+	for _, tc := range suite.TestCases {
+		tcs[tc.Name] = tc
+	}
+	if tc := tcs["redis:7.2.4"]; tc.Failure == nil || !strings.Contains(tc.Failure.Text, "CVE-3") {
+		t.Fatalf("expected redis to fail, got %+v", tc)
+	}
+	if tc := tcs["alpine:3.20"]; tc.Error == nil || tc.Error.Message != "scanner failed" {
+		t.Fatalf("expected alpine to error, got %+v", tc)
+	}
+	if tc := tcs["busybox:1.36"]; tc.Skipped == nil {
+		t.Fatalf("expected busybox to be skipped, got %+v", tc)
+	}
+	tc := tcs["nginx:1.25"]
+	if tc.Failure != nil || tc.Error != nil || !strings.Contains(tc.SystemOut, "CVE-2(9.00): not reachable") {
+		t.Fatalf("expected nginx to pass, got %+v", tc)
+	}
+}
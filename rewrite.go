@@ -0,0 +1,138 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// RewriteRuleConfig describes a single rewrite rule. Exactly one of Prefix or
+// Regexp should be set. Prefix rules replace a leading prefix of the image name
+// with Replace, Regexp rules are anchored to the start of the image name and
+// Replace may refer to capture groups (e.g. $1)
+type RewriteRuleConfig struct {
+	Prefix  string `json:"prefix,omitempty" yaml:"prefix,omitempty"` // literal prefix to replace
+	Regexp  string `json:"regexp,omitempty" yaml:"regexp,omitempty"` // regexp to match
+	Replace string `json:"replace" yaml:"replace"`                   // replacement string
+}
+
+// RewriteRule is a compiled RewriteRuleConfig
+type RewriteRule struct {
+	prefix  string
+	re      *regexp.Regexp
+	replace string
+}
+
+// Rewrite applies the rule to img, returning the new image name, and true
+// if the rule matched
+func (r RewriteRule) Rewrite(img string) (string, bool) {
+	if r.re == nil {
+		if !strings.HasPrefix(img, r.prefix) {
+			return "", false
+		}
+		return r.replace + strings.TrimPrefix(img, r.prefix), true
+	}
+
+	loc := r.re.FindStringSubmatchIndex(img)
+	if loc == nil {
+		return "", false
+	}
+
+	res := r.re.ExpandString(nil, r.replace, img, loc)
+	return string(res) + img[loc[1]:], true
+}
+
+// String returns the rule source, for logging
+func (r RewriteRule) String() string {
+	if r.re == nil {
+		return r.prefix
+	}
+	return r.re.String()
+}
+
+// CompileRewriteRules compiles a set of rewrite rule configs, the order of
+// the rules is preserved
+func CompileRewriteRules(cfgs []RewriteRuleConfig) ([]RewriteRule, error) {
+	var rules []RewriteRule
+	for i, cfg := range cfgs {
+		switch {
+		case cfg.Prefix != "" && cfg.Regexp != "":
+			return nil, fmt.Errorf("rewrite rule %d, only one of prefix or regexp may be set", i)
+		case cfg.Prefix != "":
+			rules = append(rules, RewriteRule{prefix: cfg.Prefix, replace: cfg.Replace})
+		case cfg.Regexp != "":
+			re, err := regexp.Compile("^(?:" + cfg.Regexp + ")")
+			if err != nil {
+				return nil, fmt.Errorf("rewrite rule %d, failed to compile regexp, %w", i, err)
+			}
+			rules = append(rules, RewriteRule{re: re, replace: cfg.Replace})
+		default:
+			return nil, fmt.Errorf("rewrite rule %d, one of prefix or regexp must be set", i)
+		}
+	}
+	return rules, nil
+}
+
+// RewriteRemapper is a Remapper implementation that renames images using an
+// ordered list of rewrite rules. Rules are matched against the fully qualified
+// image name (e.g docker.io/library/nginx:latest), the first matching rule is
+// used. Images that match no rule are left unchanged.
+type RewriteRemapper struct {
+	Logger
+	history map[string]string
+	Ignore  *regexp.Regexp
+	Rules   []RewriteRule
+}
+
+// ReMap renames the image using the first matching rewrite rule
+func (t *RewriteRemapper) ReMap(h *History) error {
+	ref := h.Latest()
+
+	img := ref.String()
+	if img == "" {
+		h.Record("rewrite", "empty reference", nil)
+		return nil
+	}
+	if t.Ignore != nil && t.Ignore.MatchString(img) {
+		h.Record("rewrite", fmt.Sprintf("ignored by regexp %s", t.Ignore), nil)
+		return nil
+	}
+
+	fullImg := FormatReference(ref, RefStyleFull)
+	for _, r := range t.Rules {
+		newName, ok := r.Rewrite(fullImg)
+		if !ok {
+			continue
+		}
+
+		newRef, err := ParseReference(newName)
+		if err != nil {
+			return fmt.Errorf("rewrite rule %s produced an invalid reference %q, %w", r, newName, err)
+		}
+
+		if t.history == nil {
+			t.history = map[string]string{}
+		}
+
+		origStr := FormatReference(h.Original(), RefStyleFull)
+		if existing, ok := t.history[origStr]; ok && existing != newRef.String() {
+			return fmt.Errorf("rewrite remapping must be one to one, cannot map %s to %s aswell as %s", origStr, existing, newRef)
+		}
+
+		if t.Logger != nil {
+			t.Debug("rewrote image", slog.String("rule", r.String()), slog.String("src", img), slog.String("dst", newRef.String()))
+		}
+
+		t.history[origStr] = newRef.String()
+		h.Record("rewrite", fmt.Sprintf("matched rewrite rule %s", r), newRef)
+
+		return nil
+	}
+
+	h.Record("rewrite", "no rewrite rule matched", nil)
+	return nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestCompileRewriteRules(t *testing.T) {
+	var tests = []struct {
+		in          []RewriteRuleConfig
+		expectedErr string
+	}{
+		{
+			[]RewriteRuleConfig{{Prefix: "quay.io/", Regexp: "quay.io/", Replace: "x"}},
+			"rewrite rule 0, only one of prefix or regexp may be set",
+		},
+		{
+			[]RewriteRuleConfig{{Replace: "x"}},
+			"rewrite rule 0, one of prefix or regexp must be set",
+		},
+		{
+			[]RewriteRuleConfig{{Prefix: "quay.io/", Replace: "x"}, {Regexp: "(", Replace: "x"}},
+			"rewrite rule 1, failed to compile regexp, error parsing regexp: missing closing ): `^(?:()`",
+		},
+	}
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			_, err := CompileRewriteRules(tt.in)
+			if err == nil {
+				t.Fatalf("no error, but expected error was %q", tt.expectedErr)
+			}
+			if err.Error() != tt.expectedErr {
+				t.Fatalf("wrong error:\n  exp: %s\n  got: %v\n", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestRewriteRemapper(t *testing.T) {
+	rules, err := CompileRewriteRules([]RewriteRuleConfig{
+		{Regexp: `docker.io/library/(.*)`, Replace: "mirror.example.com/dockerhub/$1"},
+		{Prefix: "quay.io/", Replace: "mirror.example.com/quay/"},
+		{Regexp: `([^/]+)/(.*)`, Replace: "mirror.example.com/other/$1/$2"},
+	})
+	if err != nil {
+		t.Fatalf("test borked, %v", err)
+	}
+
+	var tests = []struct {
+		in          string
+		exp         string
+		expectedErr string
+	}{
+		{"nginx", "mirror.example.com/dockerhub/nginx:latest", ""},
+		{"docker.io/library/redis:7", "mirror.example.com/dockerhub/redis:7", ""},
+		{"quay.io/prometheus/node-exporter:v1.8.0", "mirror.example.com/quay/prometheus/node-exporter:v1.8.0", ""},
+		{"ghcr.io/cerbos/cerbos:0.40.0", "mirror.example.com/other/ghcr.io/cerbos/cerbos:0.40.0", ""},
+	}
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ref, err := name.ParseReference(tt.in)
+			if err != nil {
+				t.Fatalf("test borked, %v", err)
+			}
+			h := NewHistory(ref)
+
+			rr := &RewriteRemapper{Rules: rules}
+			err = rr.ReMap(h)
+			if err != nil {
+				t.Fatalf("remap failed, %v", err)
+			}
+
+			if h.Latest().String() != tt.exp {
+				t.Fatalf("incorrect latest tag:\n  got: %s\n  exp: %s\n", h.Latest(), tt.exp)
+			}
+		})
+	}
+}
+
+func TestRewriteRemapper_NoMatch(t *testing.T) {
+	rules, err := CompileRewriteRules([]RewriteRuleConfig{
+		{Prefix: "quay.io/", Replace: "mirror.example.com/quay/"},
+	})
+	if err != nil {
+		t.Fatalf("test borked, %v", err)
+	}
+
+	ref, _ := name.ParseReference("ghcr.io/cerbos/cerbos:0.40.0")
+	h := NewHistory(ref)
+
+	rr := &RewriteRemapper{Rules: rules}
+	err = rr.ReMap(h)
+	if err != nil {
+		t.Fatalf("remap failed, %v", err)
+	}
+
+	if len(h.Refs) != 1 {
+		t.Fatalf("expected no remapping, got %v", h.Refs)
+	}
+}
+
+func TestRewriteRemapper_InvalidResult(t *testing.T) {
+	rules, err := CompileRewriteRules([]RewriteRuleConfig{
+		{Prefix: "quay.io/", Replace: "Not A Valid Ref/"},
+	})
+	if err != nil {
+		t.Fatalf("test borked, %v", err)
+	}
+
+	ref, _ := name.ParseReference("quay.io/prometheus/node-exporter:v1.8.0")
+	h := NewHistory(ref)
+
+	rr := &RewriteRemapper{Rules: rules}
+	err = rr.ReMap(h)
+	if err == nil {
+		t.Fatalf("expected an error for an invalid rewritten reference")
+	}
+}
+
+func TestRewriteRemapper_OneToOne(t *testing.T) {
+	rules, err := CompileRewriteRules([]RewriteRuleConfig{
+		{Regexp: `docker.io/library/nginx:1\.25\.3`, Replace: "mirror.example.com/pinned/nginx:1.25.3"},
+		{Regexp: `docker.io/library/(.*)`, Replace: "mirror.example.com/dockerhub/$1"},
+	})
+	if err != nil {
+		t.Fatalf("test borked, %v", err)
+	}
+
+	rr := &RewriteRemapper{Rules: rules}
+
+	ref, _ := name.ParseReference("nginx:1.25")
+	err = rr.ReMap(NewHistory(ref))
+	if err != nil {
+		t.Fatalf("remap failed, %v", err)
+	}
+
+	// the same original image, resolved to a different tag by an earlier
+	// remapper, is matched by a different rule
+	resolved, _ := name.ParseReference("nginx:1.25.3")
+	h := NewHistory(ref)
+	h.Add(resolved)
+	err = rr.ReMap(h)
+	if err == nil || !strings.Contains(err.Error(), "rewrite remapping must be one to one") {
+		t.Fatalf("expected one to one error, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// versionTagRe matches tags of the form [v]MAJOR[.MINOR[.PATCH]][-SUFFIX]
+var versionTagRe = regexp.MustCompile(`^(v?)(\d+(?:\.\d+){0,2})(-.+)?$`)
+
+type versionTag struct {
+	prefix  string
+	version string
+	suffix  string
+}
+
+func parseVersionTag(tag string) (versionTag, bool) {
+	m := versionTagRe.FindStringSubmatch(tag)
+	if m == nil {
+		return versionTag{}, false
+	}
+	return versionTag{prefix: m[1], version: m[2], suffix: m[3]}, true
+}
+
+func (vt versionTag) parts() int {
+	return strings.Count(vt.version, ".") + 1
+}
+
+// SemverPolicy controls which images are resolved by the SemverRemapper
+type SemverPolicy struct {
+	Images     *regexp.Regexp      // Only resolve images matching this expression, no images are resolved if nil
+	Constraint *semver.Constraints // If set, used instead of the constraint derived from the requested tag
+}
+
+// SemverRemapper is a Remapper implementation that resolves floating or partial
+// version tags (e.g. nginx:1.25, redis:7) to the highest existing tag in the
+// source repository that matches a semver constraint. If no explicit constraint
+// is set in the policy, one is derived from the requested tag, so 1.25 will match
+// any 1.25.x, and 7 any 7.x.y. Only tags with the same "v" prefix and "-" suffix
+// (e.g. 1.25-alpine resolves to 1.25.3-alpine) as the requested tag are
+// considered. Both the requested and resolved tag are recorded in the History.
+// The remapper only acts on images that have not already been moved to a
+// different repository, so should be placed before any renaming remappers.
+type SemverRemapper struct {
+	Logger
+	Policy SemverPolicy
+}
+
+// ReMap resolves the latest tag in the history to the highest matching
+// version tag in the source repository
+func (t *SemverRemapper) ReMap(h *History) error {
+	if t.Policy.Images == nil {
+		return nil
+	}
+
+	tag, ok := h.Latest().(name.Tag)
+	if !ok || tag.Context() != h.Original().Context() {
+		return nil
+	}
+
+	if !t.Policy.Images.MatchString(tag.String()) {
+		h.Record("semver", fmt.Sprintf("not matched by regexp %s", t.Policy.Images), nil)
+		return nil
+	}
+
+	req, ok := parseVersionTag(tag.TagStr())
+	constraint := t.Policy.Constraint
+	switch {
+	case constraint != nil:
+	case !ok || req.parts() == 3:
+		h.Record("semver", "not a partial version tag", nil)
+		return nil
+	default:
+		var err error
+		constraint, err = semver.NewConstraint("~" + req.version)
+		if err != nil {
+			return fmt.Errorf("could not build version constraint for %s, %w", tag, err)
+		}
+	}
+
+	tags, err := crane.ListTags(tag.Context().String())
+	if err != nil {
+		return fmt.Errorf("could not list tags for %s, %w", tag.Context(), err)
+	}
+
+	var best *semver.Version
+	bestTag := ""
+	for _, cand := range tags {
+		vt, ok := parseVersionTag(cand)
+		if !ok || vt.parts() != 3 || vt.prefix != req.prefix || vt.suffix != req.suffix {
+			continue
+		}
+		v, err := semver.NewVersion(vt.version)
+		if err != nil || !constraint.Check(v) {
+			continue
+		}
+		if best == nil || v.GreaterThan(best) {
+			best = v
+			bestTag = cand
+		}
+	}
+
+	if best == nil {
+		return fmt.Errorf("no tag of %s matches version constraint %s", tag.Context(), constraint)
+	}
+
+	resolved := tag.Context().Tag(bestTag)
+	digestStr, err := crane.Digest(resolved.String())
+	if err != nil {
+		return fmt.Errorf("failed reading digest for %s, %w", resolved, err)
+	}
+
+	if t.Logger != nil {
+		t.Debug("resolved image tag", slog.String("requested", tag.String()), slog.String("resolved", resolved.String()), slog.String("constraint", constraint.String()))
+	}
+
+	h.Resolution = &TagResolution{
+		Requested: tag,
+		Resolved:  resolved,
+	}
+	h.Record("semver", fmt.Sprintf("resolved %s using constraint %s", tag.TagStr(), constraint), resolved)
+	h.AddDigest(resolved.Context().Digest(digestStr))
+
+	return nil
+}
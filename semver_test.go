@@ -0,0 +1,83 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestSemverRemapper(t *testing.T) {
+	host := newTestRegistry(t)
+
+	repo := fmt.Sprintf("%s/test/img1", host)
+
+	digests := map[string]string{}
+	for _, tag := range []string{"1.24.9", "1.25.1", "1.25.3", "1.25.3-alpine", "1.26.0", "v2.0.0", "latest"} {
+		digests[tag] = pushRandomImage(t, repo+":"+tag).DigestStr()
+	}
+
+	var tests = []struct {
+		in         string
+		constraint string
+		exp        string
+	}{
+		{"1.25", "", "1.25.3"},
+		{"1", "", "1.26.0"},
+		{"1.25-alpine", "", "1.25.3-alpine"},
+		{"1.24.9", "", ""},
+		{"latest", "", ""},
+		{"latest", "<1.26", "1.25.3"},
+		{"v2", "", "v2.0.0"},
+	}
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ref, err := name.ParseReference(repo + ":" + tt.in)
+			if err != nil {
+				t.Fatalf("test borked, %v", err)
+			}
+			h := NewHistory(ref)
+
+			sr := &SemverRemapper{
+				Policy: SemverPolicy{Images: regexp.MustCompile(".*")},
+			}
+			if tt.constraint != "" {
+				sr.Policy.Constraint, err = semver.NewConstraint(tt.constraint)
+				if err != nil {
+					t.Fatalf("test borked, %v", err)
+				}
+			}
+
+			err = sr.ReMap(h)
+			if err != nil {
+				t.Fatalf("remap failed, %v", err)
+			}
+
+			if tt.exp == "" {
+				if h.Resolution != nil || len(h.Refs) != 1 {
+					t.Fatalf("expected no resolution, got %v", h.Refs)
+				}
+				return
+			}
+
+			if h.Resolution == nil {
+				t.Fatalf("expected tag to be resolved")
+			}
+			if h.Resolution.Requested.TagStr() != tt.in {
+				t.Fatalf("incorrect requested tag, got %s, exp %s", h.Resolution.Requested.TagStr(), tt.in)
+			}
+			if h.Resolution.Resolved.TagStr() != tt.exp {
+				t.Fatalf("incorrect resolved tag, got %s, exp %s", h.Resolution.Resolved.TagStr(), tt.exp)
+			}
+			if h.DigestStr != digests[tt.exp] {
+				t.Fatalf("incorrect digest, got %s, exp %s", h.DigestStr, digests[tt.exp])
+			}
+		})
+	}
+}
@@ -6,60 +6,128 @@ package reimage
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"os"
 	"os/exec"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
 )
 
+type trivyVulnerability struct {
+	CVSS map[string]struct {
+		V3Score float32
+		V2Score float32
+	}
+	VendorSeverity   map[string]int // Severities by source, these use the same values as Severity
+	PublishedDate    *time.Time
+	VulnerabilityID  string
+	PkgName          string
+	InstalledVersion string
+	FixedVersion     string
+	Severity         string
+	SeveritySource   string
+	Status           string // e.g. fixed, affected or will_not_fix
+}
+
 type trivyReport struct {
 	Results []struct {
-		Vulnerabilities []struct {
-			CVSS map[string]struct {
-				V3Score float32
-				V2Score float32
+		Vulnerabilities []trivyVulnerability
+	}
+}
+
+// score returns the score from the first preferred source that has one, or
+// the highest score from any source.
+func (v trivyVulnerability) score(pref CVSSPreference) float32 {
+	for _, src := range pref.Sources {
+		if src == CVSSSourceVendor {
+			src = v.SeveritySource
+		}
+		if cv, ok := v.CVSS[src]; ok {
+			if s := pref.Version.pick(cv.V3Score, cv.V2Score); s != 0 {
+				return s
 			}
-			VulnerabilityID string
 		}
 	}
+
+	score := float32(0.0)
+	for _, cv := range v.CVSS {
+		if s := pref.Version.pick(cv.V3Score, cv.V2Score); s > score {
+			score = s
+		}
+	}
+	return score
+}
+
+// severity returns the severity from the first preferred source that has
+// one, or the severity chosen by trivy.
+func (v trivyVulnerability) severity(pref CVSSPreference) string {
+	for _, src := range pref.Sources {
+		if src == CVSSSourceVendor {
+			src = v.SeveritySource
+		}
+		if sev, ok := v.VendorSeverity[src]; ok && Severity(sev) != SeverityUnknown {
+			return Severity(sev).String()
+		}
+	}
+	return v.Severity
 }
 
+// TrivyVulnGetter retrieves vulnerabilities by running trivy, or any command
+// compatible with trivy's JSON output. Images can be scanned by a trivy server,
+// using trivy's client/server mode.
 type TrivyVulnGetter struct {
 	Command []string
+	Server  string         // URL of a trivy server to scan with, in client/server mode, images are scanned locally if empty
+	Token   string         // Token for the trivy server, passed to trivy in the environment rather than as an arg
+	CVSS    CVSSPreference // Selects which of the scores and severities reported by trivy are used
+	Timeout time.Duration  // Passed to trivy as its scan timeout, trivy's default is used if 0
 }
 
-func (vc *TrivyVulnGetter) GetVulnerabilities(ctx context.Context, dig name.Digest) ([]ImageVulnerability, error) {
-	args := vc.Command[1:]
-	args = append(args, dig.String())
+// args returns the args to pass to the trivy command to scan the image
+func (vc *TrivyVulnGetter) args(dig name.Digest) []string {
+	args := append([]string{}, vc.Command[1:]...)
+	if vc.Server != "" {
+		args = append(args, "--server", vc.Server)
+	}
+	if vc.Timeout != 0 {
+		args = append(args, "--timeout", vc.Timeout.String())
+	}
+	return append(args, dig.String())
+}
 
+func (vc *TrivyVulnGetter) GetVulnerabilities(ctx context.Context, dig name.Digest) ([]ImageVulnerability, error) {
 	//nolint:gosec
-	cmd := exec.CommandContext(ctx, vc.Command[0], args...)
+	cmd := exec.CommandContext(ctx, vc.Command[0], vc.args(dig)...)
+	if vc.Token != "" {
+		cmd.Env = append(os.Environ(), "TRIVY_TOKEN="+vc.Token)
+	}
 	bs, err := cmd.Output()
 	if err != nil {
 		return nil, err
 	}
 
+	return parseTrivyReport(bs, vc.CVSS)
+}
+
+func parseTrivyReport(bs []byte, pref CVSSPreference) ([]ImageVulnerability, error) {
 	tr := trivyReport{}
-	err = json.Unmarshal(bs, &tr)
-	if err != nil {
-		return nil, err
+	if err := json.Unmarshal(bs, &tr); err != nil {
+		return nil, fmt.Errorf("could not parse trivy report, %w", err)
 	}
 
 	var res []ImageVulnerability
 	for _, r := range tr.Results {
 		for _, v := range r.Vulnerabilities {
-			score := float32(0.0)
-			for _, cv := range v.CVSS {
-				s := cv.V2Score
-				if cv.V3Score != 0.0 {
-					s = cv.V3Score
-				}
-				if s > score {
-					score = s
-				}
-			}
 			res = append(res, ImageVulnerability{
-				ID:   v.VulnerabilityID,
-				CVSS: score,
+				ID:           v.VulnerabilityID,
+				CVSS:         v.score(pref),
+				Severity:     v.severity(pref),
+				Package:      v.PkgName,
+				Version:      v.InstalledVersion,
+				FixedVersion: v.FixedVersion,
+				FixAvailable: v.Status == "fixed" || v.FixedVersion != "",
+				Published:    v.PublishedDate,
 			})
 		}
 	}
@@ -0,0 +1,101 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// TestTrivyClientHelper stands in for a trivy client, it fetches the report for
+// the image from the server with a plain GET, in place of trivy's client/server
+// protocol
+func TestTrivyClientHelper(t *testing.T) {
+	if os.Getenv("REIMAGE_TRIVY_CLIENT_HELPER") != "1" {
+		t.Skip("only run as a helper for TestTrivyVulnGetter_Server")
+	}
+
+	var server, timeout string
+	args := os.Args
+	for i := range args {
+		switch args[i] {
+		case "--server":
+			server = args[i+1]
+		case "--timeout":
+			timeout = args[i+1]
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server+"/report", nil)
+	if err != nil {
+		os.Exit(1)
+	}
+	q := req.URL.Query()
+	q.Set("image", args[len(args)-1])
+	q.Set("timeout", timeout)
+	req.URL.RawQuery = q.Encode()
+	req.Header.Set("Trivy-Token", os.Getenv("TRIVY_TOKEN"))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		os.Exit(1)
+	}
+	_, _ = io.Copy(os.Stdout, resp.Body)
+	os.Exit(0)
+}
+
+// TestTrivyVulnGetter_Server checks that the server, token and timeout are passed
+// through to the trivy command. The stand in client and server don't speak trivy's
+// client/server protocol, so this doesn't check compatibility with a real server.
+func TestTrivyVulnGetter_Server(t *testing.T) {
+	dig, err := name.NewDigest("example.com/nginx@" + testDigestStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotImage, gotTimeout string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Trivy-Token") != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		gotImage = r.URL.Query().Get("image")
+		gotTimeout = r.URL.Query().Get("timeout")
+		http.ServeFile(w, r, "testdata/trivy.json")
+	}))
+	defer s.Close()
+
+	t.Setenv("REIMAGE_TRIVY_CLIENT_HELPER", "1")
+	t.Setenv("TRIVY_TOKEN", "")
+	vg := &TrivyVulnGetter{
+		Command: []string{os.Args[0], "-test.run=^TestTrivyClientHelper$", "--"},
+		Server:  s.URL,
+		Token:   "s3cret",
+		Timeout: 90 * time.Second,
+	}
+
+	res, err := vg.GetVulnerabilities(context.Background(), dig)
+	if err != nil {
+		t.Fatalf("could not get vulnerabilities, %v", err)
+	}
+	if gotImage != dig.String() || gotTimeout != "1m30s" {
+		t.Fatalf("incorrect args passed to trivy, image %q, timeout %q", gotImage, gotTimeout)
+	}
+	if len(res) != 2 || res[0].ID != "CVE-2023-5678" || res[0].CVSS != 5.3 || !res[0].FixAvailable || res[1].CVSS != 3.7 {
+		t.Fatalf("incorrect vulnerabilities, got %+v", res)
+	}
+
+	// without the token, the server rejects the scan
+	vg.Token = ""
+	if _, err := vg.GetVulnerabilities(context.Background(), dig); err == nil {
+		t.Fatalf("expected scan without token to fail")
+	}
+}
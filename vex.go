@@ -0,0 +1,315 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// VEXStatus is the status of a vulnerability in a VEX statement
+type VEXStatus string
+
+const (
+	VEXNotAffected        VEXStatus = "not_affected"
+	VEXAffected           VEXStatus = "affected"
+	VEXFixed              VEXStatus = "fixed"
+	VEXUnderInvestigation VEXStatus = "under_investigation"
+)
+
+// VEXArtifactTypes are the artifact types of OpenVEX documents attached to
+// images as OCI referrers
+var VEXArtifactTypes = []string{
+	"application/openvex+json",
+	"application/vnd.openvex+json",
+}
+
+var vexDigestRegexp = regexp.MustCompile(`sha256:[a-f0-9]{64}`)
+
+// VEXProduct identifies a product that a VEX statement applies to. Images are
+// matched by the digest in the product's ID (usually an OCI purl, e.g.
+// pkg:oci/nginx@sha256%3A...), identifiers, or sha-256 hash.
+type VEXProduct struct {
+	Identifiers map[string]string `json:"identifiers,omitempty"`
+	Hashes      map[string]string `json:"hashes,omitempty"`
+	ID          string            `json:"@id"`
+}
+
+// UnmarshalJSON accepts both product objects, and the plain product IDs
+// used by earlier versions of OpenVEX
+func (p *VEXProduct) UnmarshalJSON(bs []byte) error {
+	var str string
+	if err := json.Unmarshal(bs, &str); err == nil {
+		*p = VEXProduct{ID: str}
+		return nil
+	}
+
+	type plain VEXProduct
+	return json.Unmarshal(bs, (*plain)(p))
+}
+
+// digests returns the image digests that identify the product
+func (p VEXProduct) digests() []string {
+	strs := []string{p.ID}
+	for _, id := range p.Identifiers {
+		strs = append(strs, id)
+	}
+
+	var res []string
+	for _, str := range strs {
+		if unesc, err := url.PathUnescape(str); err == nil {
+			str = unesc
+		}
+		res = append(res, vexDigestRegexp.FindAllString(str, -1)...)
+	}
+	if h, ok := p.Hashes["sha-256"]; ok {
+		res = append(res, "sha256:"+h)
+	}
+	return res
+}
+
+// VEXVulnerability identifies the vulnerability a VEX statement is about
+type VEXVulnerability struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// UnmarshalJSON accepts both vulnerability objects, and the plain vulnerability
+// names used by earlier versions of OpenVEX
+func (v *VEXVulnerability) UnmarshalJSON(bs []byte) error {
+	var str string
+	if err := json.Unmarshal(bs, &str); err == nil {
+		*v = VEXVulnerability{Name: str}
+		return nil
+	}
+
+	type plain VEXVulnerability
+	return json.Unmarshal(bs, (*plain)(v))
+}
+
+// VEXStatement is a single OpenVEX statement
+type VEXStatement struct {
+	Timestamp       *time.Time       `json:"timestamp,omitempty"`
+	Vulnerability   VEXVulnerability `json:"vulnerability"`
+	Status          VEXStatus        `json:"status"`
+	Justification   string           `json:"justification,omitempty"`
+	ImpactStatement string           `json:"impact_statement,omitempty"`
+	Products        []VEXProduct     `json:"products,omitempty"`
+}
+
+// AppliesTo returns true if one of the statement's products is the image with
+// the given digest
+func (s VEXStatement) AppliesTo(digest string) bool {
+	for _, p := range s.Products {
+		if slices.Contains(p.digests(), digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// describes returns true if the statement is about the vulnerability
+func (s VEXStatement) describes(cve ImageVulnerability) bool {
+	if cve.Is(s.Vulnerability.Name) {
+		return true
+	}
+	for _, a := range s.Vulnerability.Aliases {
+		if cve.Is(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// Suppresses returns true if the statement says the product is not affected
+// by the vulnerability
+func (s VEXStatement) Suppresses() bool {
+	return s.Status == VEXNotAffected || s.Status == VEXFixed
+}
+
+// String describes the statement, for recording as the justification of an
+// ignored vulnerability
+func (s VEXStatement) String() string {
+	str := "vex " + string(s.Status)
+	if s.Justification != "" {
+		str += fmt.Sprintf(" (%s)", s.Justification)
+	}
+	if s.ImpactStatement != "" {
+		str += ": " + s.ImpactStatement
+	}
+	return str
+}
+
+// VEXDocument is an OpenVEX document
+type VEXDocument struct {
+	Timestamp  *time.Time     `json:"timestamp,omitempty"`
+	ID         string         `json:"@id"`
+	Author     string         `json:"author,omitempty"`
+	Statements []VEXStatement `json:"statements"`
+}
+
+// ParseVEXDocument parses an OpenVEX document. Statements without a timestamp
+// take the timestamp of the document.
+func ParseVEXDocument(bs []byte) (*VEXDocument, error) {
+	doc := VEXDocument{}
+	if err := json.Unmarshal(bs, &doc); err != nil {
+		return nil, fmt.Errorf("could not parse vex document, %w", err)
+	}
+
+	for i := range doc.Statements {
+		if doc.Statements[i].Timestamp == nil {
+			doc.Statements[i].Timestamp = doc.Timestamp
+		}
+	}
+
+	return &doc, nil
+}
+
+// VEXGetter is an interface to any source of the VEX statements about a given
+// docker image digest
+type VEXGetter interface {
+	GetVEXStatements(ctx context.Context, dig name.Digest) ([]VEXStatement, error)
+}
+
+// StaticVEXGetter returns the statements from a fixed set of VEX documents
+// whose products include the image
+type StaticVEXGetter struct {
+	Documents []*VEXDocument
+}
+
+// GetVEXStatements returns the statements whose products include the digest
+func (s *StaticVEXGetter) GetVEXStatements(_ context.Context, dig name.Digest) ([]VEXStatement, error) {
+	var res []VEXStatement
+	for _, doc := range s.Documents {
+		for _, st := range doc.Statements {
+			if st.AppliesTo(dig.DigestStr()) {
+				res = append(res, st)
+			}
+		}
+	}
+	return res, nil
+}
+
+// ReferrersVEXGetter returns the statements from VEX documents attached to the
+// image as OCI referrers. Statements that do not list any products are taken to
+// apply to the image they are attached to.
+type ReferrersVEXGetter struct {
+	Options []remote.Option
+}
+
+// GetVEXStatements returns the statements from attached VEX documents
+func (r *ReferrersVEXGetter) GetVEXStatements(ctx context.Context, dig name.Digest) ([]VEXStatement, error) {
+	opts := append([]remote.Option{remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain)}, r.Options...)
+
+	idx, err := remote.Referrers(dig, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not list referrers of %s, %w", dig, err)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("could not read referrers of %s, %w", dig, err)
+	}
+
+	var res []VEXStatement
+	for _, desc := range im.Manifests {
+		if !slices.Contains(VEXArtifactTypes, desc.ArtifactType) {
+			continue
+		}
+
+		img, err := remote.Image(dig.Context().Digest(desc.Digest.String()), opts...)
+		if err != nil {
+			return nil, fmt.Errorf("could not read vex document %s, %w", desc.Digest, err)
+		}
+		ls, err := img.Layers()
+		if err != nil {
+			return nil, fmt.Errorf("could not read vex document %s, %w", desc.Digest, err)
+		}
+
+		for _, l := range ls {
+			bs, err := readLayer(l.Uncompressed)
+			if err != nil {
+				return nil, fmt.Errorf("could not read vex document %s, %w", desc.Digest, err)
+			}
+			doc, err := ParseVEXDocument(bs)
+			if err != nil {
+				return nil, fmt.Errorf("vex document %s, %w", desc.Digest, err)
+			}
+			for _, st := range doc.Statements {
+				if len(st.Products) == 0 || st.AppliesTo(dig.DigestStr()) {
+					res = append(res, st)
+				}
+			}
+		}
+	}
+
+	return res, nil
+}
+
+func readLayer(open func() (io.ReadCloser, error)) ([]byte, error) {
+	rc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// MultiVEXGetter combines the statements from several VEXGetters
+type MultiVEXGetter []VEXGetter
+
+// GetVEXStatements returns the statements from all the getters
+func (m MultiVEXGetter) GetVEXStatements(ctx context.Context, dig name.Digest) ([]VEXStatement, error) {
+	var res []VEXStatement
+	var errs []error
+	for _, g := range m {
+		sts, err := g.GetVEXStatements(ctx, dig)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		res = append(res, sts...)
+	}
+	return res, errors.Join(errs...)
+}
+
+// vexIndex finds the most recent statement about each vulnerability
+type vexIndex []VEXStatement
+
+func newVEXIndex(sts []VEXStatement) vexIndex {
+	res := slices.Clone(sts)
+	// later statements override earlier ones, statements without a timestamp
+	// are treated as the oldest
+	sort.SliceStable(res, func(i, j int) bool {
+		ti, tj := res[i].Timestamp, res[j].Timestamp
+		if ti == nil || tj == nil {
+			return ti == nil && tj != nil
+		}
+		return ti.Before(*tj)
+	})
+	return res
+}
+
+// statement returns the most recent statement about the vulnerability, or
+// nil if there is none
+func (idx vexIndex) statement(cve ImageVulnerability) *VEXStatement {
+	for i := len(idx) - 1; i >= 0; i-- {
+		st := idx[i]
+		if st.describes(cve) && st.Status != VEXUnderInvestigation {
+			return &idx[i]
+		}
+	}
+	return nil
+}
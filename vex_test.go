@@ -0,0 +1,172 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const otherTestDigestStr = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+var testVEXDocument = `{
+  "@context": "https://openvex.dev/ns/v0.2.0",
+  "@id": "https://example.com/vex/1",
+  "author": "Example Security",
+  "timestamp": "2024-01-01T00:00:00Z",
+  "statements": [
+    {
+      "vulnerability": {"name": "CVE-2024-0001"},
+      "products": [{"@id": "pkg:oci/nginx@` + url.PathEscape(testDigestStr) + `?repository_url=docker.io/library/nginx"}],
+      "status": "not_affected",
+      "justification": "vulnerable_code_not_in_execute_path",
+      "impact_statement": "nginx does not load the affected module"
+    },
+    {
+      "vulnerability": {"name": "GHSA-xxxx-yyyy-zzzz", "aliases": ["CVE-2024-0002"]},
+      "products": [{"@id": "nginx", "hashes": {"sha-256": "` + testDigestStr[7:] + `"}}],
+      "status": "fixed"
+    },
+    {
+      "vulnerability": {"name": "CVE-2024-0003"},
+      "products": [{"@id": "pkg:oci/nginx@` + url.PathEscape(testDigestStr) + `"}],
+      "status": "affected"
+    },
+    {
+      "vulnerability": {"name": "CVE-2024-0004"},
+      "products": [{"@id": "pkg:oci/redis@` + url.PathEscape(otherTestDigestStr) + `"}],
+      "status": "not_affected",
+      "justification": "component_not_present"
+    },
+    {
+      "timestamp": "2024-02-01T00:00:00Z",
+      "vulnerability": {"name": "CVE-2024-0003"},
+      "products": [{"@id": "pkg:oci/nginx@` + url.PathEscape(testDigestStr) + `"}],
+      "status": "under_investigation"
+    }
+  ]
+}`
+
+// legacy documents use plain strings for vulnerabilities and products
+var testLegacyVEXDocument = `{
+  "@id": "https://example.com/vex/2",
+  "timestamp": "2023-01-01T00:00:00Z",
+  "statements": [
+    {
+      "vulnerability": "CVE-2024-0003",
+      "products": ["pkg:oci/nginx@` + url.PathEscape(testDigestStr) + `"],
+      "status": "not_affected",
+      "justification": "inline_mitigations_already_exist"
+    }
+  ]
+}`
+
+type testVulnGetter []ImageVulnerability
+
+func (g testVulnGetter) GetVulnerabilities(context.Context, name.Digest) ([]ImageVulnerability, error) {
+	return g, nil
+}
+
+func TestVulnChecker_VEX(t *testing.T) {
+	var docs []*VEXDocument
+	for _, str := range []string{testVEXDocument, testLegacyVEXDocument} {
+		doc, err := ParseVEXDocument([]byte(str))
+		if err != nil {
+			t.Fatalf("could not parse vex document, %v", err)
+		}
+		docs = append(docs, doc)
+	}
+
+	cves := []ImageVulnerability{
+		{ID: "CVE-2024-0001", CVSS: 9.0},
+		{ID: "CVE-2024-0002", CVSS: 9.0},
+		{ID: "CVE-2024-0003", CVSS: 9.0},
+		{ID: "CVE-2024-0004", CVSS: 9.0},
+	}
+
+	vc := &VulnChecker{
+		Getter:  testVulnGetter(cves),
+		VEX:     &StaticVEXGetter{Documents: docs},
+		MaxCVSS: 7.0,
+	}
+
+	dig, err := name.NewDigest("mirror.example.com/nginx@" + testDigestStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = vc.Check(context.Background(), dig)
+	ice := &ImageCheckError{}
+	if !errors.As(err, &ice) {
+		t.Fatalf("expected check to fail, got %v", err)
+	}
+	// the more recent affected statement overrides the legacy not_affected
+	// statement, and the statement about redis does not apply
+	if len(ice.CVEs) != 2 || ice.CVEs["CVE-2024-0003"] == 0 || ice.CVEs["CVE-2024-0004"] == 0 {
+		t.Fatalf("incorrect failing CVEs, got %v", ice.CVEs)
+	}
+
+	vc.Getter = testVulnGetter(cves[:2])
+	res, err := vc.Check(context.Background(), dig)
+	if err != nil {
+		t.Fatalf("unexpected failure, %v", err)
+	}
+	exp := []ImageVulnerability{
+		{ID: "CVE-2024-0001", CVSS: 9.0, Justification: "vex not_affected (vulnerable_code_not_in_execute_path): nginx does not load the affected module"},
+		{ID: "CVE-2024-0002", CVSS: 9.0, Justification: "vex fixed"},
+	}
+	if !reflect.DeepEqual(res.Ignored, exp) {
+		t.Fatalf("incorrect ignored CVEs\n  got: %+v\n  exp: %+v", res.Ignored, exp)
+	}
+}
+
+func TestReferrersVEXGetter(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dig := pushImage(t, fmt.Sprintf("%s/test/nginx:1.25", newTestRegistry(t)), img)
+	imgDig, _ := img.Digest()
+	imgMT, _ := img.MediaType()
+	imgSize, _ := img.Size()
+
+	// attached statements without products apply to the subject
+	vexDoc := fmt.Sprintf(`{"@id": "https://example.com/vex/3", "statements": [
+	  {"vulnerability": {"name": "CVE-2024-0001"}, "status": "not_affected", "justification": "component_not_present"},
+	  {"vulnerability": {"name": "CVE-2024-0002"}, "products": [{"@id": "pkg:oci/redis@%s"}], "status": "not_affected"}
+	]}`, url.PathEscape(otherTestDigestStr))
+
+	vex := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	vex = mutate.ConfigMediaType(vex, types.MediaType(VEXArtifactTypes[0]))
+	vex, err = mutate.Append(vex, mutate.Addendum{Layer: static.NewLayer([]byte(vexDoc), types.MediaType(VEXArtifactTypes[0]))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vex, _ = mutate.Subject(vex, v1.Descriptor{MediaType: imgMT, Digest: imgDig, Size: imgSize}).(v1.Image)
+	vexDig, _ := vex.Digest()
+	if err := remote.Write(dig.Context().Digest(vexDig.String()), vex); err != nil {
+		t.Fatal(err)
+	}
+
+	sts, err := (&ReferrersVEXGetter{}).GetVEXStatements(context.Background(), dig)
+	if err != nil {
+		t.Fatalf("could not get vex statements, %v", err)
+	}
+	if len(sts) != 1 || sts[0].Vulnerability.Name != "CVE-2024-0001" || sts[0].Status != VEXNotAffected {
+		t.Fatalf("incorrect statements, got %+v", sts)
+	}
+}
@@ -0,0 +1,615 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// ErrNoStoredVulnerabilities is returned by the StoredVulnGetter for images
+// that have no recorded vulnerability check
+var ErrNoStoredVulnerabilities = errors.New("no stored vulnerability check results for image")
+
+// Severity is the severity of a vulnerability, as reported by a scanner. The values
+// match those used by trivy.
+type Severity int
+
+const (
+	SeverityUnknown Severity = iota
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+var severityNames = []string{"UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// severityAliases maps other names used by scanners and vendors onto severities
+var severityAliases = map[string]Severity{
+	"":                     SeverityUnknown,
+	"UNSPECIFIED":          SeverityUnknown,
+	"SEVERITY_UNSPECIFIED": SeverityUnknown,
+	"NEGLIGIBLE":           SeverityLow,
+	"MINIMAL":              SeverityLow,
+	"MODERATE":             SeverityMedium,
+	"IMPORTANT":            SeverityHigh,
+}
+
+// severityMinScore is the lowest CVSS v3 score for each severity
+var severityMinScore = map[Severity]float32{
+	SeverityLow:      0.1,
+	SeverityMedium:   4.0,
+	SeverityHigh:     7.0,
+	SeverityCritical: 9.0,
+}
+
+// ParseSeverity parses a severity name, case insensitively. As well as the
+// names of the Severity constants, some aliases used by vendors (e.g. MODERATE,
+// IMPORTANT) are accepted.
+func ParseSeverity(str string) (Severity, error) {
+	str = strings.ToUpper(strings.TrimSpace(str))
+	for i, n := range severityNames {
+		if n == str {
+			return Severity(i), nil
+		}
+	}
+	if sev, ok := severityAliases[str]; ok {
+		return sev, nil
+	}
+	return SeverityUnknown, fmt.Errorf("unknown severity %q", str)
+}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return severityNames[SeverityUnknown]
+	}
+	return severityNames[s]
+}
+
+// MarshalText marshals the severity as its name
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText parses the severity from its name
+func (s *Severity) UnmarshalText(bs []byte) error {
+	sev, err := ParseSeverity(string(bs))
+	if err != nil {
+		return err
+	}
+	*s = sev
+	return nil
+}
+
+// UnscoredPolicy controls how the VulnChecker treats vulnerabilities without a
+// CVSS score
+type UnscoredPolicy string
+
+const (
+	UnscoredPass     UnscoredPolicy = "pass"     // Unscored vulnerabilities only fail a MaxSeverity policy (the default)
+	UnscoredFail     UnscoredPolicy = "fail"     // Unscored vulnerabilities always fail
+	UnscoredSeverity UnscoredPolicy = "severity" // Unscored vulnerabilities are given the lowest score of their severity
+)
+
+// ParseUnscoredPolicy parses the name of an unscored vulnerability policy
+func ParseUnscoredPolicy(str string) (UnscoredPolicy, error) {
+	switch p := UnscoredPolicy(str); p {
+	case UnscoredPass, UnscoredFail, UnscoredSeverity:
+		return p, nil
+	case "":
+		return UnscoredPass, nil
+	default:
+		return "", fmt.Errorf("unknown unscored vulnerability policy %q, should be pass, fail or severity", str)
+	}
+}
+
+// CVSSVersion selects the version of CVSS scores to use
+type CVSSVersion string
+
+const (
+	CVSSAnyVersion CVSSVersion = ""   // Use v3 scores, falling back to v2
+	CVSSV3         CVSSVersion = "v3" // Only use v3 scores
+	CVSSV2         CVSSVersion = "v2" // Only use v2 scores
+)
+
+// ParseCVSSVersion parses a CVSS version, (v3, v2, or an empty string for any)
+func ParseCVSSVersion(str string) (CVSSVersion, error) {
+	switch v := CVSSVersion(str); v {
+	case CVSSAnyVersion, CVSSV3, CVSSV2:
+		return v, nil
+	default:
+		return "", fmt.Errorf("unknown CVSS version %q, should be v3 or v2", str)
+	}
+}
+
+// pick returns the score of the preferred version
+func (v CVSSVersion) pick(v3, v2 float32) float32 {
+	switch v {
+	case CVSSV3:
+		return v3
+	case CVSSV2:
+		return v2
+	default:
+		if v3 != 0 {
+			return v3
+		}
+		return v2
+	}
+}
+
+// CVSSSourceVendor is a CVSS source that refers to the vendor of the affected
+// package (e.g. the distribution), rather than a fixed source.
+const CVSSSourceVendor = "vendor"
+
+// CVSSPreference selects which of the scores and severities reported for a
+// vulnerability is used, when a scanner reports several
+type CVSSPreference struct {
+	Version CVSSVersion // The version of CVSS scores to use
+	Sources []string    // Sources of scores and severities (e.g. nvd, vendor, redhat) in order of preference
+}
+
+// VulnGetter is an interface to any tool that can retrieve vulnerabilities for
+// a given docker image digest
+type VulnGetter interface {
+	GetVulnerabilities(ctx context.Context, dig name.Digest) ([]ImageVulnerability, error)
+}
+
+// VulnChecker checks that images have been scanned, and checks that
+// they do not contain unexpected vulnerabilities
+type VulnChecker struct {
+	Getter VulnGetter
+	Logger
+	VEX           VEXGetter // Source of VEX statements, not_affected and fixed vulnerabilities are ignored
+	IgnoreImages  *regexp.Regexp
+	cveAllowList  map[string]struct{}
+	now           func() time.Time // for testing
+	KEV           *KEVCatalog      // Known exploited vulnerabilities, these fail the check regardless of their score
+	EPSS          *EPSSScores      // Exploit prediction scores, checked against MinEPSS
+	Unscored      UnscoredPolicy   // How to treat vulnerabilities with no CVSS score
+	Exploited     ExploitedPolicy  // Whether exploited vulnerabilities fail the check, or are only recorded
+	CVEIgnoreList []string
+	Exceptions    []CVEException // Scoped, expiring, vulnerability exceptions
+
+	MaxSeverity       Severity      // Maximum allowed severity, not checked if SeverityUnknown
+	NewCVEGracePeriod time.Duration // Vulnerabilities published more recently than this are reported as found, those with no published date are checked as normal
+	MinEPSS           float64       // Vulnerabilities with at least this EPSS probability are treated as exploited, not checked if 0
+	Timeout           time.Duration // Maximum time to check each image in CheckImages, unlimited if 0
+	Concurrency       int           // Maximum number of images checked at once by CheckImages, unlimited if 0
+	sync.Mutex
+	MaxCVSS     float32 // Maximum allowed CVSS score, not checked if 0
+	FixableOnly bool    // Only fail on vulnerabilities with a fix available, others are reported as found
+}
+
+// ImageCheckError is returned by Check if unwanted vulnerabilities are found
+type ImageCheckError struct {
+	CVEs        map[string]float32
+	Image       string
+	Expired     []CVEException // Expired exceptions that would have ignored some of the CVEs
+	Exploited   []string       // The sources that list failing CVEs as known, or likely, to be exploited
+	MaxCVSS     float32
+	MaxSeverity Severity
+}
+
+func (ice *ImageCheckError) Error() string {
+	cvsStrs := []string{}
+	for cve, score := range ice.CVEs {
+		cvsStrs = append(cvsStrs, fmt.Sprintf("%s(%.2f)", cve, score))
+	}
+	sort.Strings(cvsStrs)
+
+	var limits []string
+	if ice.MaxCVSS != 0 {
+		limits = append(limits, fmt.Sprintf("score > %.2f", ice.MaxCVSS))
+	}
+	if ice.MaxSeverity != SeverityUnknown {
+		limits = append(limits, fmt.Sprintf("severity > %s", ice.MaxSeverity))
+	}
+	if len(ice.Exploited) != 0 {
+		limits = append(limits, "known exploits")
+	}
+	if len(limits) == 0 {
+		limits = append(limits, "no score")
+	}
+
+	str := fmt.Sprintf(
+		"image %s has %d CVEs with %s: %s",
+		ice.Image,
+		len(ice.CVEs),
+		strings.Join(limits, " or "),
+		strings.Join(cvsStrs, ","),
+	)
+
+	if len(ice.Expired) != 0 {
+		var expStrs []string
+		for _, e := range ice.Expired {
+			expStrs = append(expStrs, e.String())
+		}
+		str += fmt.Sprintf(", expired exceptions: %s", strings.Join(expStrs, ","))
+	}
+
+	if len(ice.Exploited) != 0 {
+		str += fmt.Sprintf(", exploited: %s", strings.Join(ice.Exploited, ","))
+	}
+
+	return str
+}
+
+// ImageVulnerability describes a vulnerability found in an image
+type ImageVulnerability struct {
+	ID            string     `json:"id"`
+	Severity      string     `json:"severity,omitempty"`      // The severity reported by the scanner, e.g. LOW or CRITICAL
+	Package       string     `json:"package,omitempty"`       // The affected package
+	Version       string     `json:"version,omitempty"`       // The installed version of the package
+	FixedVersion  string     `json:"fixedVersion,omitempty"`  // The version of the package that fixes the vulnerability, if known
+	Published     *time.Time `json:"published,omitempty"`     // When the vulnerability was published, if known
+	Justification string     `json:"justification,omitempty"` // Why an ignored vulnerability was ignored, from its exception
+	Exploited     string     `json:"exploited,omitempty"`     // The sources that list the vulnerability as known, or likely, to be exploited
+	Aliases       []string   `json:"aliases,omitempty"`       // Other IDs for the vulnerability, e.g. the CVE for a GHSA
+	CVSS          float32    `json:"cvss,omitempty"`
+	FixAvailable  bool       `json:"fixAvailable,omitempty"` // The scanner reported that a fix is available
+}
+
+// UnmarshalJSON accepts both the structured form, and the ID:score strings
+// recorded in older mappings
+func (iv *ImageVulnerability) UnmarshalJSON(bs []byte) error {
+	var str string
+	if err := json.Unmarshal(bs, &str); err == nil {
+		id, scoreStr, ok := strings.Cut(str, ":")
+		*iv = ImageVulnerability{ID: id}
+		if !ok {
+			return nil
+		}
+		score, err := strconv.ParseFloat(scoreStr, 32)
+		if err != nil {
+			return fmt.Errorf("could not parse score of vulnerability %q, %w", str, err)
+		}
+		iv.CVSS = float32(score)
+		return nil
+	}
+
+	type plain ImageVulnerability
+	return json.Unmarshal(bs, (*plain)(iv))
+}
+
+// Fixable returns true if a fix is available for the vulnerability
+func (iv ImageVulnerability) Fixable() bool {
+	return iv.FixAvailable || iv.FixedVersion != ""
+}
+
+// Is returns true if id is the ID, or one of the aliases, of the vulnerability
+func (iv ImageVulnerability) Is(id string) bool {
+	return iv.ID == id || slices.Contains(iv.Aliases, id)
+}
+
+func (iv ImageVulnerability) String() string {
+	return fmt.Sprintf("%s(%.2f)", iv.ID, iv.CVSS)
+}
+
+// VulnCheckResult is the result of a vulnerability check
+type VulnCheckResult struct {
+	Ignored []ImageVulnerability // CVEs that were present, but explicitly ignored by the checker
+	Found   []ImageVulnerability // CVEs that were present, but under the max requested CVSS
+	Failed  []ImageVulnerability // CVEs that violate the policy, the check fails if there are any
+	Expired []CVEException       // Expired exceptions that would have ignored some of the CVEs
+	Skipped bool                 // The image matched IgnoreImages, and was not checked
+}
+
+// Check waits for a completed vulnerability discovery, and then check that an image
+// has no CVEs that violate the configured policy. If any do, the result is returned
+// along with an *ImageCheckError
+func (vc *VulnChecker) Check(ctx context.Context, dig name.Digest) (*VulnCheckResult, error) {
+	img := dig.String()
+	if vc.IgnoreImages != nil && vc.IgnoreImages.MatchString(img) {
+		return &VulnCheckResult{Skipped: true}, nil
+	}
+
+	cves, err := vc.Getter.GetVulnerabilities(ctx, dig)
+	if err != nil {
+		return nil, err
+	}
+
+	var vex vexIndex
+	if vc.VEX != nil {
+		sts, err := vc.VEX.GetVEXStatements(ctx, dig)
+		if err != nil {
+			return nil, fmt.Errorf("could not get vex statements, %w", err)
+		}
+		vex = newVEXIndex(sts)
+	}
+
+	return vc.evaluate(dig.Name(), cves, vex)
+}
+
+// ImageCheck is the outcome of checking one of the images passed to CheckImages
+type ImageCheck struct {
+	Err    error            // Why the check failed, an *ImageCheckError if the image violates the policy
+	Result *VulnCheckResult // The result of the check, if it completed
+	Image  QualifiedImage   // The image, with the results of a successful check recorded
+}
+
+// CheckImages checks all the images, running at most Concurrency checks at once.
+// The outcome of each check is returned, keyed as in imgs, along with the errors
+// of any checks that failed.
+func (vc *VulnChecker) CheckImages(ctx context.Context, imgs map[string]QualifiedImage) (map[string]ImageCheck, error) {
+	log := vc.Logger
+	if log == nil {
+		log = DefaultLogger
+	}
+
+	srcs := make([]string, 0, len(imgs))
+	for src := range imgs {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+
+	checks := make([]ImageCheck, len(srcs))
+	forEach(len(srcs), vc.Concurrency, func(i int) {
+		img := imgs[srcs[i]]
+		checks[i] = ImageCheck{Image: img}
+
+		vcCtx := ctx
+		if vc.Timeout != 0 {
+			var vcCancel context.CancelFunc
+			vcCtx, vcCancel = context.WithTimeoutCause(ctx, vc.Timeout, errors.New("timeout waiting for vuln-check"))
+			defer vcCancel()
+		}
+
+		log.Debug("start checks on", "img", img.Tag)
+		dig, err := img.DigestReference()
+		if err != nil {
+			checks[i].Err = err
+			return
+		}
+
+		res, err := vc.Check(vcCtx, dig)
+		if err != nil {
+			checks[i].Err = fmt.Errorf("image check failed %q, %w", img, err)
+			checks[i].Result = res
+			return
+		}
+
+		img.FoundCVEs = res.Found
+		img.IgnoredCVEs = res.Ignored
+		if !res.Skipped {
+			img.CheckedAt = vc.checkedAt(dig)
+		}
+		checks[i] = ImageCheck{Image: img, Result: res}
+	})
+
+	res := make(map[string]ImageCheck, len(srcs))
+	errs := make([]error, len(srcs))
+	for i, src := range srcs {
+		res[src] = checks[i]
+		errs[i] = checks[i].Err
+	}
+
+	return res, joinErrors(errs)
+}
+
+// checkedAt returns when the image was scanned. Stored results keep the time
+// of the original scan.
+func (vc *VulnChecker) checkedAt(dig name.Digest) *time.Time {
+	if s, ok := vc.Getter.(*StoredVulnGetter); ok {
+		return s.CheckedAt(dig)
+	}
+	checked := time.Now().UTC()
+	return &checked
+}
+
+// Evaluate applies the configured policy to the vulnerabilities found in an image,
+// without retrieving them. This allows a policy to be re-applied to previously
+// retrieved vulnerabilities
+func (vc *VulnChecker) Evaluate(img string, cves []ImageVulnerability) (*VulnCheckResult, error) {
+	return vc.evaluate(img, cves, nil)
+}
+
+func (vc *VulnChecker) evaluate(img string, cves []ImageVulnerability, vex vexIndex) (*VulnCheckResult, error) {
+	vc.Lock()
+	if vc.cveAllowList == nil {
+		vc.cveAllowList = map[string]struct{}{}
+		for _, str := range vc.CVEIgnoreList {
+			vc.cveAllowList[str] = struct{}{}
+		}
+	}
+	vc.Unlock()
+
+	res := VulnCheckResult{}
+	if !vc.Enabled() {
+		return &res, nil
+	}
+
+	now := time.Now()
+	if vc.now != nil {
+		now = vc.now()
+	}
+
+	badCVEs := map[string]float32{}
+	var exploits []string
+	for _, cve := range cves {
+		// justifications may have been recorded by a previous check
+		cve.Justification = ""
+		cve.Exploited = vc.exploited(cve)
+		exploited := cve.Exploited != "" && vc.Exploited != ExploitedWarn
+		if exploited || vc.violates(cve) {
+			if vc.allowed(cve) {
+				res.Ignored = append(res.Ignored, cve)
+				continue
+			}
+			if st := vex.statement(cve); st != nil && st.Suppresses() {
+				cve.Justification = st.String()
+				res.Ignored = append(res.Ignored, cve)
+				continue
+			}
+			exc, expired := vc.exception(cve, img, now)
+			res.Expired = append(res.Expired, expired...)
+			if exc != nil {
+				cve.Justification = exc.Justification
+				res.Ignored = append(res.Ignored, cve)
+				continue
+			}
+			if !exploited && vc.deferred(cve, now) {
+				res.Found = append(res.Found, cve)
+				continue
+			}
+			badCVEs[cve.ID] = cve.CVSS
+			res.Failed = append(res.Failed, cve)
+			if exploited {
+				exploits = append(exploits, cve.Exploited)
+			}
+			continue
+		}
+		res.Found = append(res.Found, cve)
+	}
+
+	if len(badCVEs) != 0 {
+		return &res, &ImageCheckError{
+			Image:       img,
+			MaxCVSS:     vc.MaxCVSS,
+			MaxSeverity: vc.MaxSeverity,
+			CVEs:        badCVEs,
+			Expired:     res.Expired,
+			Exploited:   exploits,
+		}
+	}
+
+	return &res, nil
+}
+
+// Enabled returns true if the checker has a policy to check, otherwise all
+// vulnerabilities pass
+func (vc *VulnChecker) Enabled() bool {
+	return vc.MaxCVSS != 0 || vc.MaxSeverity != SeverityUnknown || vc.KEV != nil || (vc.EPSS != nil && vc.MinEPSS != 0)
+}
+
+// deferred returns true if the vulnerability should not fail the check yet,
+// because it can't be fixed, or it was published within the grace period
+func (vc *VulnChecker) deferred(cve ImageVulnerability, now time.Time) bool {
+	if vc.FixableOnly && !cve.Fixable() {
+		return true
+	}
+	return vc.NewCVEGracePeriod != 0 && cve.Published != nil && now.Sub(*cve.Published) < vc.NewCVEGracePeriod
+}
+
+// allowed returns true if the vulnerability is in the CVEIgnoreList
+func (vc *VulnChecker) allowed(cve ImageVulnerability) bool {
+	for id := range vc.cveAllowList {
+		if cve.Is(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// exception returns the first unexpired exception for the vulnerability in
+// img, and any expired exceptions that would otherwise have applied
+func (vc *VulnChecker) exception(cve ImageVulnerability, img string, now time.Time) (*CVEException, []CVEException) {
+	var expired []CVEException
+	for i, e := range vc.Exceptions {
+		if !e.Applies(cve, img) {
+			continue
+		}
+		if e.Expired(now) {
+			expired = append(expired, e)
+			continue
+		}
+		return &vc.Exceptions[i], expired
+	}
+	return nil, expired
+}
+
+// violates returns true if the vulnerability is not permitted by the policy
+func (vc *VulnChecker) violates(cve ImageVulnerability) bool {
+	// severities we don't recognise are treated as unknown
+	sev, _ := ParseSeverity(cve.Severity)
+
+	score := cve.CVSS
+	if score == 0 {
+		switch vc.Unscored {
+		case UnscoredFail:
+			return true
+		case UnscoredSeverity:
+			score = severityMinScore[sev]
+		}
+	}
+
+	if vc.MaxCVSS != 0 && score > vc.MaxCVSS {
+		return true
+	}
+	return vc.MaxSeverity != SeverityUnknown && sev > vc.MaxSeverity
+}
+
+// StoredVulnGetter is a VulnGetter that returns the vulnerabilities recorded
+// in a set of mappings by a previous check, so that a new policy can be applied
+// without rescanning. Only the vulnerabilities that passed the previous policy,
+// or were ignored by it, are recorded.
+type StoredVulnGetter struct {
+	byDigest  map[string][]ImageVulnerability
+	checkedAt map[string]*time.Time
+}
+
+// NewStoredVulnGetter creates a StoredVulnGetter for the images in mappings.
+// Images must have been checked (have a CheckedAt time), or have recorded
+// vulnerabilities (for mappings written before CheckedAt was recorded).
+func NewStoredVulnGetter(mappings map[string]QualifiedImage) *StoredVulnGetter {
+	res := &StoredVulnGetter{
+		byDigest:  map[string][]ImageVulnerability{},
+		checkedAt: map[string]*time.Time{},
+	}
+
+	for _, img := range mappings {
+		if img.CheckedAt == nil && len(img.FoundCVEs) == 0 && len(img.IgnoredCVEs) == 0 {
+			continue
+		}
+
+		seen := map[string]struct{}{}
+		vulns := res.byDigest[img.Digest]
+		for _, v := range vulns {
+			seen[v.ID] = struct{}{}
+		}
+		for _, v := range append(append([]ImageVulnerability{}, img.FoundCVEs...), img.IgnoredCVEs...) {
+			if _, ok := seen[v.ID]; ok {
+				continue
+			}
+			seen[v.ID] = struct{}{}
+			vulns = append(vulns, v)
+		}
+		if vulns == nil {
+			vulns = []ImageVulnerability{}
+		}
+		res.byDigest[img.Digest] = vulns
+
+		if prev := res.checkedAt[img.Digest]; img.CheckedAt != nil && (prev == nil || img.CheckedAt.After(*prev)) {
+			res.checkedAt[img.Digest] = img.CheckedAt
+		}
+	}
+
+	return res
+}
+
+// CheckedAt returns when the stored vulnerabilities for the digest were found,
+// or nil if that was not recorded.
+func (s *StoredVulnGetter) CheckedAt(dig name.Digest) *time.Time {
+	return s.checkedAt[dig.DigestStr()]
+}
+
+// GetVulnerabilities returns the stored vulnerabilities for the digest
+func (s *StoredVulnGetter) GetVulnerabilities(_ context.Context, dig name.Digest) ([]ImageVulnerability, error) {
+	vulns, ok := s.byDigest[dig.DigestStr()]
+	if !ok {
+		return nil, fmt.Errorf("%s, %w", dig, ErrNoStoredVulnerabilities)
+	}
+	return vulns, nil
+}
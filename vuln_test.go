@@ -0,0 +1,305 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestImageVulnerability_UnmarshalJSON(t *testing.T) {
+	var img QualifiedImage
+	err := json.Unmarshal([]byte(`{
+		"tag": "mirror.example.com/nginx:1.25",
+		"digest": "`+testDigestStr+`",
+		"foundCVEs": ["CVE-2024-0001:5.500000", {"id": "CVE-2024-0002", "cvss": 4.2, "severity": "MEDIUM", "package": "openssl", "version": "3.0.1", "fixedVersion": "3.0.2"}],
+		"ignoredCVEs": ["CVE-2024-0003"]
+	}`), &img)
+	if err != nil {
+		t.Fatalf("could not parse mapping, %v", err)
+	}
+
+	exp := []ImageVulnerability{
+		{ID: "CVE-2024-0001", CVSS: 5.5},
+		{ID: "CVE-2024-0002", CVSS: 4.2, Severity: "MEDIUM", Package: "openssl", Version: "3.0.1", FixedVersion: "3.0.2"},
+	}
+	if !reflect.DeepEqual(img.FoundCVEs, exp) {
+		t.Fatalf("incorrect found CVEs\n  got: %+v\n  exp: %+v", img.FoundCVEs, exp)
+	}
+	if !reflect.DeepEqual(img.IgnoredCVEs, []ImageVulnerability{{ID: "CVE-2024-0003"}}) {
+		t.Fatalf("incorrect ignored CVEs, got %+v", img.IgnoredCVEs)
+	}
+
+	if err := json.Unmarshal([]byte(`"CVE-1:high"`), &ImageVulnerability{}); err == nil {
+		t.Fatalf("expected error for invalid legacy score")
+	}
+}
+
+func TestVulnChecker_StoredReevaluation(t *testing.T) {
+	checked := time.Now()
+	mappings := map[string]QualifiedImage{
+		"nginx:1.25": {
+			Tag:         "mirror.example.com/nginx:1.25",
+			Digest:      testDigestStr,
+			FoundCVEs:   []ImageVulnerability{{ID: "CVE-1", CVSS: 5.0}, {ID: "CVE-2", CVSS: 2.0}},
+			IgnoredCVEs: []ImageVulnerability{{ID: "CVE-3", CVSS: 9.0}},
+			CheckedAt:   &checked,
+		},
+		"redis:7.2.4": {
+			Tag:       "mirror.example.com/redis:7.2.4",
+			Digest:    "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			CheckedAt: &checked,
+		},
+		"alpine:3.20": {
+			Tag:    "mirror.example.com/alpine:3.20",
+			Digest: "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+		},
+	}
+	getter := NewStoredVulnGetter(mappings)
+
+	digest := func(img string) name.Digest {
+		ref, _ := ParseReference(mappings[img].Tag)
+		return ref.Context().Digest(mappings[img].Digest)
+	}
+
+	// the time of the original scan is kept
+	if at := getter.CheckedAt(digest("nginx:1.25")); at == nil || !at.Equal(checked) {
+		t.Fatalf("incorrect checked time, got %v", at)
+	}
+	if at := getter.CheckedAt(digest("alpine:3.20")); at != nil {
+		t.Fatalf("unchecked image had a checked time, got %v", at)
+	}
+
+	ctx := context.Background()
+
+	// the original policy still passes
+	vc := &VulnChecker{Getter: getter, MaxCVSS: 7.0, CVEIgnoreList: []string{"CVE-3"}}
+	res, err := vc.Check(ctx, digest("nginx:1.25"))
+	if err != nil {
+		t.Fatalf("original policy failed, %v", err)
+	}
+	if len(res.Found) != 2 || len(res.Ignored) != 1 || res.Ignored[0].ID != "CVE-3" {
+		t.Fatalf("incorrect result, got %+v", res)
+	}
+
+	// a stricter policy fails, without a rescan
+	vc = &VulnChecker{Getter: getter, MaxCVSS: 4.0}
+	_, err = vc.Check(ctx, digest("nginx:1.25"))
+	ice := &ImageCheckError{}
+	if !errors.As(err, &ice) || len(ice.CVEs) != 2 || ice.CVEs["CVE-1"] != 5.0 || ice.CVEs["CVE-3"] != 9.0 {
+		t.Fatalf("expected stricter policy to fail on CVE-1 and CVE-3, got %v", err)
+	}
+
+	// a checked image with no CVEs passes
+	if _, err := vc.Check(ctx, digest("redis:7.2.4")); err != nil {
+		t.Fatalf("clean image failed, %v", err)
+	}
+
+	// an image that was never checked has no stored results
+	if _, err := vc.Check(ctx, digest("alpine:3.20")); !errors.Is(err, ErrNoStoredVulnerabilities) {
+		t.Fatalf("expected unchecked image to fail, got %v", err)
+	}
+}
+
+func TestVulnChecker_SeverityPolicy(t *testing.T) {
+	cves := []ImageVulnerability{
+		{ID: "CVE-1", CVSS: 5.0, Severity: "MEDIUM"},
+		{ID: "CVE-2", Severity: "CRITICAL"},
+		{ID: "CVE-3", Severity: "LOW"},
+		{ID: "CVE-4", CVSS: 8.0, Severity: "moderate"},
+		{ID: "CVE-5"},
+	}
+
+	var tests = []struct {
+		vc  *VulnChecker
+		exp string // the CVEs that fail the policy
+	}{
+		{&VulnChecker{MaxCVSS: 7.0}, "CVE-4"},
+		{&VulnChecker{MaxCVSS: 7.0, Unscored: UnscoredSeverity}, "CVE-2,CVE-4"},
+		{&VulnChecker{MaxCVSS: 7.0, Unscored: UnscoredFail}, "CVE-2,CVE-3,CVE-4,CVE-5"},
+		{&VulnChecker{MaxSeverity: SeverityMedium}, "CVE-2"},
+		{&VulnChecker{MaxSeverity: SeverityLow}, "CVE-1,CVE-2,CVE-4"},
+		{&VulnChecker{MaxCVSS: 7.0, MaxSeverity: SeverityHigh}, "CVE-2,CVE-4"},
+		{&VulnChecker{MaxCVSS: 7.0, MaxSeverity: SeverityHigh, CVEIgnoreList: []string{"CVE-2"}}, "CVE-4"},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			_, err := tt.vc.Evaluate("nginx:1.25", cves)
+			var got []string
+			ice := &ImageCheckError{}
+			if errors.As(err, &ice) {
+				for id := range ice.CVEs {
+					got = append(got, id)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error, %v", err)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != tt.exp {
+				t.Fatalf("incorrect failing CVEs\n  got: %v\n  exp: %s", got, tt.exp)
+			}
+		})
+	}
+}
+
+func TestTrivyVulnerability_CVSSPreference(t *testing.T) {
+	var v trivyVulnerability
+	err := json.Unmarshal([]byte(`{
+		"VulnerabilityID": "CVE-1",
+		"Severity": "MEDIUM",
+		"SeveritySource": "debian",
+		"VendorSeverity": {"debian": 2, "nvd": 4},
+		"CVSS": {
+			"nvd": {"V2Score": 7.5, "V3Score": 9.8},
+			"debian": {"V2Score": 5.0},
+			"redhat": {"V3Score": 6.5}
+		}
+	}`), &v)
+	if err != nil {
+		t.Fatalf("could not parse vulnerability, %v", err)
+	}
+
+	var tests = []struct {
+		pref  CVSSPreference
+		score float32
+		sev   string
+	}{
+		{CVSSPreference{}, 9.8, "MEDIUM"},
+		{CVSSPreference{Version: CVSSV2}, 7.5, "MEDIUM"},
+		{CVSSPreference{Sources: []string{"redhat", "nvd"}}, 6.5, "CRITICAL"},
+		{CVSSPreference{Sources: []string{CVSSSourceVendor}}, 5.0, "MEDIUM"},
+		{CVSSPreference{Sources: []string{CVSSSourceVendor}, Version: CVSSV3}, 9.8, "MEDIUM"},
+		{CVSSPreference{Sources: []string{"nvd"}, Version: CVSSV2}, 7.5, "CRITICAL"},
+		{CVSSPreference{Sources: []string{"ghsa"}}, 9.8, "MEDIUM"},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if got := v.score(tt.pref); got != tt.score {
+				t.Fatalf("incorrect score, got %.2f, exp %.2f", got, tt.score)
+			}
+			if got := v.severity(tt.pref); got != tt.sev {
+				t.Fatalf("incorrect severity, got %s, exp %s", got, tt.sev)
+			}
+		})
+	}
+}
+
+func TestVulnChecker_FixableOnly(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	recent := now.Add(-24 * time.Hour)
+	old := now.Add(-30 * 24 * time.Hour)
+
+	cves := []ImageVulnerability{
+		{ID: "CVE-1", CVSS: 9.0, FixedVersion: "1.2.3", Published: &old},
+		{ID: "CVE-2", CVSS: 9.0, FixAvailable: true, Published: &recent},
+		{ID: "CVE-3", CVSS: 9.0},
+		{ID: "CVE-4", CVSS: 9.0, FixedVersion: "2.0.0"},
+	}
+
+	var tests = []struct {
+		vc  *VulnChecker
+		exp string // the CVEs that fail the policy
+	}{
+		{&VulnChecker{MaxCVSS: 7.0}, "CVE-1,CVE-2,CVE-3,CVE-4"},
+		{&VulnChecker{MaxCVSS: 7.0, FixableOnly: true}, "CVE-1,CVE-2,CVE-4"},
+		{&VulnChecker{MaxCVSS: 7.0, NewCVEGracePeriod: 7 * 24 * time.Hour}, "CVE-1,CVE-3,CVE-4"},
+		{&VulnChecker{MaxCVSS: 7.0, FixableOnly: true, NewCVEGracePeriod: 7 * 24 * time.Hour}, "CVE-1,CVE-4"},
+		{&VulnChecker{MaxCVSS: 7.0, FixableOnly: true, NewCVEGracePeriod: 60 * 24 * time.Hour}, "CVE-4"},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			tt.vc.now = func() time.Time { return now }
+			res, err := tt.vc.Evaluate("nginx:1.25", cves)
+			var got []string
+			ice := &ImageCheckError{}
+			if errors.As(err, &ice) {
+				for id := range ice.CVEs {
+					got = append(got, id)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error, %v", err)
+			} else if len(res.Found) != len(cves) {
+				t.Fatalf("deferred CVEs should be found, got %+v", res.Found)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != tt.exp {
+				t.Fatalf("incorrect failing CVEs\n  got: %v\n  exp: %s", got, tt.exp)
+			}
+		})
+	}
+}
+
+func TestVulnChecker_CheckImages(t *testing.T) {
+	scanned := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	imgs := map[string]QualifiedImage{
+		"nginx:1.25": {
+			Tag:       "mirror.example.com/nginx:1.25",
+			Digest:    testDigestStr,
+			CheckedAt: &scanned,
+			FoundCVEs: []ImageVulnerability{{ID: "CVE-1", CVSS: 5.0}, {ID: "CVE-2", CVSS: 2.0}},
+		},
+		"redis:7.2.4": {
+			Tag:       "mirror.example.com/redis:7.2.4",
+			Digest:    otherTestDigestStr,
+			FoundCVEs: []ImageVulnerability{{ID: "CVE-3", CVSS: 9.0}},
+		},
+		"busybox:1.36": {
+			Tag:    "mirror.example.com/busybox:1.36",
+			Digest: "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+		},
+		"alpine:3.20": {
+			Tag:    "mirror.example.com/alpine:3.20",
+			Digest: "sha256:3333333333333333333333333333333333333333333333333333333333333333",
+		},
+	}
+
+	vc := &VulnChecker{
+		Getter:       NewStoredVulnGetter(imgs),
+		IgnoreImages: regexp.MustCompile("busybox"),
+		MaxCVSS:      7.0,
+		Concurrency:  2,
+	}
+
+	checks, err := vc.CheckImages(context.Background(), imgs)
+	ice := &ImageCheckError{}
+	if !errors.As(err, &ice) || ice.CVEs["CVE-3"] != 9.0 {
+		t.Fatalf("expected redis to fail, got %v", err)
+	}
+	if !errors.Is(err, ErrNoStoredVulnerabilities) {
+		t.Fatalf("expected alpine to fail, got %v", err)
+	}
+	if len(checks) != len(imgs) {
+		t.Fatalf("expected a check for every image, got %d", len(checks))
+	}
+
+	nginx := checks["nginx:1.25"]
+	if nginx.Err != nil || nginx.Image.CheckedAt == nil || !nginx.Image.CheckedAt.Equal(scanned) || len(nginx.Image.FoundCVEs) != 2 {
+		t.Fatalf("incorrect nginx check, got %+v", nginx)
+	}
+	busybox := checks["busybox:1.36"]
+	if busybox.Err != nil || !busybox.Result.Skipped || busybox.Image.CheckedAt != nil {
+		t.Fatalf("incorrect busybox check, got %+v", busybox)
+	}
+	redis := checks["redis:7.2.4"]
+	if redis.Err == nil || len(redis.Result.Failed) != 1 || redis.Image.CheckedAt != nil {
+		t.Fatalf("incorrect redis check, got %+v", redis)
+	}
+	alpine := checks["alpine:3.20"]
+	if alpine.Err == nil || alpine.Result != nil {
+		t.Fatalf("incorrect alpine check, got %+v", alpine)
+	}
+}
@@ -0,0 +1,319 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	// VulnCacheArtifactType is the artifact type of cached scan results attached
+	// to images as OCI referrers
+	VulnCacheArtifactType = "application/vnd.cerbos.reimage.vulns.v1+json"
+
+	// AnnotationVulnScanner records the identity of the scanner that produced
+	// cached scan results
+	AnnotationVulnScanner = "dev.cerbos.reimage.vulns.scanner"
+)
+
+// VulnCacheEntry is a cached set of scan results
+type VulnCacheEntry struct {
+	Created         time.Time            `json:"created"`
+	Scanner         string               `json:"scanner"`
+	Vulnerabilities []ImageVulnerability `json:"vulnerabilities"`
+}
+
+// VulnCache stores scan results by image digest and scanner identity
+type VulnCache interface {
+	// Get returns the most recent entry for the image and scanner, or nil if
+	// there is none
+	Get(ctx context.Context, scanner string, dig name.Digest) (*VulnCacheEntry, error)
+	Put(ctx context.Context, dig name.Digest, entry *VulnCacheEntry) error
+}
+
+// CachingVulnGetter is a VulnGetter that caches the results of another VulnGetter.
+// Errors reading or writing the cache are logged, and do not fail the check.
+type CachingVulnGetter struct {
+	Getter VulnGetter
+	Cache  VulnCache
+	Logger
+	Scanner string        // Identifies the scanner, results from other scanners are not used
+	TTL     time.Duration // How long results are used for, forever if 0
+}
+
+func (c *CachingVulnGetter) GetVulnerabilities(ctx context.Context, dig name.Digest) ([]ImageVulnerability, error) {
+	log := c.Logger
+	if log == nil {
+		log = DefaultLogger
+	}
+
+	entry, err := c.Cache.Get(ctx, c.Scanner, dig)
+	switch {
+	case err != nil:
+		log.Info("could not read cached vulnerabilities", "img", dig.String(), "error", err)
+	case entry == nil:
+		log.Debug("no cached vulnerabilities", "img", dig.String())
+	case c.TTL != 0 && time.Since(entry.Created) >= c.TTL:
+		log.Debug("cached vulnerabilities have expired", "img", dig.String(), "created", entry.Created)
+	default:
+		log.Debug("using cached vulnerabilities", "img", dig.String(), "created", entry.Created)
+		return entry.Vulnerabilities, nil
+	}
+
+	vulns, err := c.Getter.GetVulnerabilities(ctx, dig)
+	if err != nil {
+		return nil, err
+	}
+
+	entry = &VulnCacheEntry{
+		Created:         time.Now().UTC(),
+		Scanner:         c.Scanner,
+		Vulnerabilities: vulns,
+	}
+	if err := c.Cache.Put(ctx, dig, entry); err != nil {
+		log.Info("could not cache vulnerabilities", "img", dig.String(), "error", err)
+	}
+
+	return vulns, nil
+}
+
+// scannerKey returns a short, filename safe, key for the scanner identity
+func scannerKey(scanner string) string {
+	h := sha256.Sum256([]byte(scanner))
+	return hex.EncodeToString(h[:8])
+}
+
+// DirVulnCache stores scan results as files in a directory
+type DirVulnCache struct {
+	Dir string
+}
+
+func (d *DirVulnCache) path(scanner string, dig name.Digest) string {
+	h, _ := v1.NewHash(dig.DigestStr())
+	return filepath.Join(d.Dir, fmt.Sprintf("%s-%s-%s.json", h.Algorithm, h.Hex, scannerKey(scanner)))
+}
+
+// Get returns the cached entry for the image and scanner
+func (d *DirVulnCache) Get(_ context.Context, scanner string, dig name.Digest) (*VulnCacheEntry, error) {
+	bs, err := os.ReadFile(d.path(scanner, dig))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := VulnCacheEntry{}
+	if err := json.Unmarshal(bs, &entry); err != nil {
+		return nil, fmt.Errorf("could not parse cache entry, %w", err)
+	}
+	if entry.Scanner != scanner {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+// Put writes the entry, replacing any existing entry for the image and scanner
+func (d *DirVulnCache) Put(_ context.Context, dig name.Digest, entry *VulnCacheEntry) error {
+	bs, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(d.Dir, 0o755); err != nil {
+		return fmt.Errorf("could not create cache dir, %w", err)
+	}
+
+	// write to a temporary file first, so concurrent readers never see partial entries
+	f, err := os.CreateTemp(d.Dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("could not create cache entry, %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(bs); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write cache entry, %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("could not write cache entry, %w", err)
+	}
+
+	return os.Rename(f.Name(), d.path(entry.Scanner, dig))
+}
+
+// ReferrersVulnCache stores scan results as artifacts attached to the scanned
+// images as OCI referrers. Each new entry supersedes, and deletes, the previous
+// entries for the same scanner.
+type ReferrersVulnCache struct {
+	Options []remote.Option
+}
+
+func (r *ReferrersVulnCache) options(ctx context.Context) []remote.Option {
+	return append([]remote.Option{remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain)}, r.Options...)
+}
+
+// referrerEntry is a cache entry attached to an image
+type referrerEntry struct {
+	created time.Time
+	digest  name.Digest
+}
+
+// entries returns the cache entries attached to the image for the scanner, most
+// recent first
+func (r *ReferrersVulnCache) entries(scanner string, dig name.Digest, opts []remote.Option) ([]referrerEntry, error) {
+	idx, err := remote.Referrers(dig, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not list referrers of %s, %w", dig, err)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("could not read referrers of %s, %w", dig, err)
+	}
+
+	var res []referrerEntry
+	for _, desc := range im.Manifests {
+		// registries that don't support artifactType list artifacts by their
+		// config media type
+		if desc.ArtifactType != VulnCacheArtifactType && desc.ArtifactType != string(OCIEmptyMediaType) {
+			continue
+		}
+		entryDig := dig.Context().Digest(desc.Digest.String())
+
+		// registries don't always include the annotations in the referrers
+		// list, in which case we read them from the manifest
+		annos := desc.Annotations
+		if _, ok := annos[AnnotationVulnScanner]; !ok || desc.ArtifactType != VulnCacheArtifactType {
+			img, err := remote.Image(entryDig, opts...)
+			if err != nil {
+				return nil, fmt.Errorf("could not read cache entry %s, %w", desc.Digest, err)
+			}
+			at, err := artifactType(img)
+			if err != nil {
+				return nil, fmt.Errorf("could not read cache entry %s, %w", desc.Digest, err)
+			}
+			if at != VulnCacheArtifactType {
+				continue
+			}
+			mf, err := img.Manifest()
+			if err != nil {
+				return nil, fmt.Errorf("could not read cache entry %s, %w", desc.Digest, err)
+			}
+			annos = mf.Annotations
+		}
+
+		if annos[AnnotationVulnScanner] != scannerKey(scanner) {
+			continue
+		}
+		created, err := time.Parse(time.RFC3339, annos[AnnotationCreated])
+		if err != nil {
+			continue
+		}
+		res = append(res, referrerEntry{created: created, digest: entryDig})
+	}
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].created.After(res[j].created) })
+	return res, nil
+}
+
+// Get returns the most recent attached entry for the scanner
+func (r *ReferrersVulnCache) Get(ctx context.Context, scanner string, dig name.Digest) (*VulnCacheEntry, error) {
+	opts := r.options(ctx)
+
+	entries, err := r.entries(scanner, dig, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	latest, err := remote.Image(entries[0].digest, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not read cache entry %s, %w", entries[0].digest, err)
+	}
+	ls, err := latest.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("could not read cache entry, %w", err)
+	}
+	if len(ls) != 1 {
+		return nil, fmt.Errorf("cache entry should have 1 layer, found %d", len(ls))
+	}
+	bs, err := readLayer(ls[0].Uncompressed)
+	if err != nil {
+		return nil, fmt.Errorf("could not read cache entry, %w", err)
+	}
+
+	entry := VulnCacheEntry{}
+	if err := json.Unmarshal(bs, &entry); err != nil {
+		return nil, fmt.Errorf("could not parse cache entry, %w", err)
+	}
+	if entry.Scanner != scanner {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+// Put attaches the entry to the image
+func (r *ReferrersVulnCache) Put(ctx context.Context, dig name.Digest, entry *VulnCacheEntry) error {
+	opts := r.options(ctx)
+
+	subject, err := remote.Head(dig, opts...)
+	if err != nil {
+		return fmt.Errorf("could not read %s, %w", dig, err)
+	}
+
+	bs, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	img, err := newArtifact(VulnCacheArtifactType, static.NewLayer(bs, types.MediaType("application/json")), nil, map[string]string{
+		AnnotationCreated:     entry.Created.UTC().Format(time.RFC3339),
+		AnnotationVulnScanner: scannerKey(entry.Scanner),
+	}, &v1.Descriptor{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size})
+	if err != nil {
+		return fmt.Errorf("could not create cache entry, %w", err)
+	}
+
+	superseded, err := r.entries(entry.Scanner, dig, opts)
+	if err != nil {
+		return err
+	}
+
+	imgDig, err := img.Digest()
+	if err != nil {
+		return err
+	}
+	if err := remote.Write(dig.Context().Digest(imgDig.String()), img, opts...); err != nil {
+		return err
+	}
+
+	for _, old := range superseded {
+		if old.digest.DigestStr() == imgDig.String() {
+			continue
+		}
+		if err := remote.Delete(old.digest, opts...); err != nil {
+			return fmt.Errorf("could not delete superseded cache entry %s, %w", old.digest, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2021-2024 Zenauth Ltd.
+// SPDX-License-Identifier: Apache-2.0
+
+package reimage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+type countingVulnGetter struct {
+	vulns []ImageVulnerability
+	calls int
+}
+
+func (g *countingVulnGetter) GetVulnerabilities(context.Context, name.Digest) ([]ImageVulnerability, error) {
+	g.calls++
+	return g.vulns, nil
+}
+
+func testVulnCache(t *testing.T, cache VulnCache, dig name.Digest) {
+	t.Helper()
+
+	ctx := context.Background()
+	vulns := []ImageVulnerability{{ID: "CVE-1", CVSS: 5.0, Severity: "MEDIUM"}}
+	getter := &countingVulnGetter{vulns: vulns}
+
+	cg := &CachingVulnGetter{Getter: getter, Cache: cache, Scanner: "trivy image -f json", TTL: time.Hour}
+	for i := 0; i < 2; i++ {
+		res, err := cg.GetVulnerabilities(ctx, dig)
+		if err != nil {
+			t.Fatalf("get failed, %v", err)
+		}
+		if !reflect.DeepEqual(res, vulns) {
+			t.Fatalf("incorrect vulnerabilities, got %+v", res)
+		}
+	}
+	if getter.calls != 1 {
+		t.Fatalf("expected results to be cached, scanner was called %d times", getter.calls)
+	}
+
+	// results from other scanners are not used
+	other := &CachingVulnGetter{Getter: getter, Cache: cache, Scanner: "grype -o json"}
+	if _, err := other.GetVulnerabilities(ctx, dig); err != nil {
+		t.Fatalf("get failed, %v", err)
+	}
+	if getter.calls != 2 {
+		t.Fatalf("expected other scanner to miss the cache, scanner was called %d times", getter.calls)
+	}
+
+	// expired results are replaced
+	expiring := &CachingVulnGetter{Getter: getter, Cache: cache, Scanner: cg.Scanner, TTL: time.Nanosecond}
+	if _, err := expiring.GetVulnerabilities(ctx, dig); err != nil {
+		t.Fatalf("get failed, %v", err)
+	}
+	if getter.calls != 3 {
+		t.Fatalf("expected expired entry to be rescanned, scanner was called %d times", getter.calls)
+	}
+	if _, err := cg.GetVulnerabilities(ctx, dig); err != nil {
+		t.Fatalf("get failed, %v", err)
+	}
+	if getter.calls != 3 {
+		t.Fatalf("expected new entry to be cached, scanner was called %d times", getter.calls)
+	}
+}
+
+func TestDirVulnCache(t *testing.T) {
+	dig, err := name.NewDigest("example.com/nginx@" + testDigestStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testVulnCache(t, &DirVulnCache{Dir: t.TempDir()}, dig)
+}
+
+func TestReferrersVulnCache(t *testing.T) {
+	dig := pushRandomImage(t, fmt.Sprintf("%s/test/nginx:1.25", newTestRegistry(t)))
+	testVulnCache(t, &ReferrersVulnCache{}, dig)
+
+	// superseded entries are removed, leaving the latest entry for each scanner
+	idx, err := remote.Referrers(dig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(im.Manifests) != 2 {
+		t.Fatalf("expected only the latest cache entry of each scanner to be attached, found %d", len(im.Manifests))
+	}
+}